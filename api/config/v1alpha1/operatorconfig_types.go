@@ -0,0 +1,99 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	instv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+)
+
+// LanguageJava is the key of the Java agent image in InstrumentationDefaults.Images.
+const LanguageJava = "java"
+
+// InstrumentationDefaults are used for the settings missing in the instrumentation CRs.
+type InstrumentationDefaults struct {
+	// Images maps the language to the default agent image, e.g. java.
+	Images map[string]string `json:"images,omitempty"`
+	// OTLPEndpoint is the default OTLP endpoint of the instrumented workloads.
+	OTLPEndpoint string `json:"OTLPEndpoint,omitempty"`
+	// InitContainerResources are the default resources of the init container copying the agent.
+	InitContainerResources *corev1.ResourceRequirements `json:"initContainerResources,omitempty"`
+	// InitContainerSecurityContext is the default security context of the init container copying the agent.
+	InitContainerSecurityContext *corev1.SecurityContext `json:"initContainerSecurityContext,omitempty"`
+}
+
+// RateLimiterConfig configures the workqueue rate limiter of a reconciler.
+type RateLimiterConfig struct {
+	// BaseDelay is the first retry delay of a failed reconciliation, the delay doubles with every failure.
+	BaseDelay *metav1.Duration `json:"baseDelay,omitempty"`
+	// MaxDelay caps the retry delay of a failed reconciliation.
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+	// QPS is the overall rate of the queued reconciliations.
+	QPS *float64 `json:"qps,omitempty"`
+	// Burst is the number of reconciliations queued above the QPS rate.
+	Burst *int `json:"burst,omitempty"`
+}
+
+// ClientConnectionConfig configures the client of the Kubernetes API.
+type ClientConnectionConfig struct {
+	// QPS is the rate of the requests to the API server.
+	QPS *float32 `json:"qps,omitempty"`
+	// Burst is the number of requests sent above the QPS rate.
+	Burst *int `json:"burst,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OperatorConfig is the configuration file of the operator. The manager settings are read at the start,
+// the other settings are reloaded when the file changes. Unset settings keep the defaults of the flags.
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Instrumentation holds the defaults of the instrumentation CRs.
+	Instrumentation InstrumentationDefaults `json:"instrumentation,omitempty"`
+	// InstrumentationLabel is the label which enables or disables the instrumentation, empty disables the label.
+	InstrumentationLabel *string `json:"instrumentationLabel,omitempty"`
+	// InstrumentationAnnotation is the annotation which enables or disables the instrumentation,
+	// empty disables the annotation.
+	InstrumentationAnnotation *string `json:"instrumentationAnnotation,omitempty"`
+	// ExcludedNamespaces are glob patterns of namespaces which are never instrumented.
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// Mode is either Enforce or Audit, Audit runs all instrumentation CRs in the audit mode.
+	// The --dry-run flag set on the command line takes precedence over the mode.
+	Mode instv1alpha1.Mode `json:"mode,omitempty"`
+	// MaxConcurrentRollouts caps the Deployments with an in-progress rollout in the cluster, zero means unlimited.
+	MaxConcurrentRollouts *int `json:"maxConcurrentRollouts,omitempty"`
+	// MaxConcurrentRolloutsPerNamespace caps the Deployments with an in-progress rollout in a namespace,
+	// zero means unlimited.
+	MaxConcurrentRolloutsPerNamespace *int `json:"maxConcurrentRolloutsPerNamespace,omitempty"`
+	// GroupKindRateLimiters configures the rate limiters of the reconcilers by the group kind of the reconciled
+	// objects, e.g. Deployment.apps or OpenTelemetryInstrumentation.opentelemetry.io. The concurrency of the
+	// reconcilers is set by controller.groupKindConcurrency.
+	GroupKindRateLimiters map[string]RateLimiterConfig `json:"groupKindRateLimiters,omitempty"`
+	// ClientConnection configures the client of the Kubernetes API.
+	ClientConnection ClientConnectionConfig `json:"clientConnection,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
@@ -0,0 +1,179 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClientConnectionConfig) DeepCopyInto(out *ClientConnectionConfig) {
+	*out = *in
+	if in.QPS != nil {
+		in, out := &in.QPS, &out.QPS
+		*out = new(float32)
+		**out = **in
+	}
+	if in.Burst != nil {
+		in, out := &in.Burst, &out.Burst
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClientConnectionConfig.
+func (in *ClientConnectionConfig) DeepCopy() *ClientConnectionConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ClientConnectionConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstrumentationDefaults) DeepCopyInto(out *InstrumentationDefaults) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.InitContainerResources != nil {
+		in, out := &in.InitContainerResources, &out.InitContainerResources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InitContainerSecurityContext != nil {
+		in, out := &in.InitContainerSecurityContext, &out.InitContainerSecurityContext
+		*out = new(v1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstrumentationDefaults.
+func (in *InstrumentationDefaults) DeepCopy() *InstrumentationDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(InstrumentationDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperatorConfig) DeepCopyInto(out *OperatorConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	in.Instrumentation.DeepCopyInto(&out.Instrumentation)
+	if in.InstrumentationLabel != nil {
+		in, out := &in.InstrumentationLabel, &out.InstrumentationLabel
+		*out = new(string)
+		**out = **in
+	}
+	if in.InstrumentationAnnotation != nil {
+		in, out := &in.InstrumentationAnnotation, &out.InstrumentationAnnotation
+		*out = new(string)
+		**out = **in
+	}
+	if in.ExcludedNamespaces != nil {
+		in, out := &in.ExcludedNamespaces, &out.ExcludedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxConcurrentRollouts != nil {
+		in, out := &in.MaxConcurrentRollouts, &out.MaxConcurrentRollouts
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxConcurrentRolloutsPerNamespace != nil {
+		in, out := &in.MaxConcurrentRolloutsPerNamespace, &out.MaxConcurrentRolloutsPerNamespace
+		*out = new(int)
+		**out = **in
+	}
+	if in.GroupKindRateLimiters != nil {
+		in, out := &in.GroupKindRateLimiters, &out.GroupKindRateLimiters
+		*out = make(map[string]RateLimiterConfig, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	in.ClientConnection.DeepCopyInto(&out.ClientConnection)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperatorConfig.
+func (in *OperatorConfig) DeepCopy() *OperatorConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(OperatorConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OperatorConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimiterConfig) DeepCopyInto(out *RateLimiterConfig) {
+	*out = *in
+	if in.BaseDelay != nil {
+		in, out := &in.BaseDelay, &out.BaseDelay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxDelay != nil {
+		in, out := &in.MaxDelay, &out.MaxDelay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.QPS != nil {
+		in, out := &in.QPS, &out.QPS
+		*out = new(float64)
+		**out = **in
+	}
+	if in.Burst != nil {
+		in, out := &in.Burst, &out.Burst
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimiterConfig.
+func (in *RateLimiterConfig) DeepCopy() *RateLimiterConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimiterConfig)
+	in.DeepCopyInto(out)
+	return out
+}
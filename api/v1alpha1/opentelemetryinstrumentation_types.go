@@ -17,20 +17,128 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// ConditionValid reports whether the instrumentation configuration can be injected.
+	ConditionValid = "Valid"
+
+	// ReasonValid is set on the Valid condition when the configuration is correct.
+	ReasonValid = "Valid"
+	// ReasonInvalidResourceAttributes is set on the Valid condition when a resource attribute template is broken.
+	ReasonInvalidResourceAttributes = "InvalidResourceAttributes"
+	// ReasonInvalidSelector is set on the Valid condition when the workload or namespace selector is broken.
+	ReasonInvalidSelector = "InvalidSelector"
+)
+
+// Mode defines how the instrumentation is applied to the workloads. The lowercase values are accepted as well.
+// +kubebuilder:validation:Enum=Enforce;Audit;enforce;audit
+type Mode string
+
+const (
+	// ModeEnforce updates the workloads.
+	ModeEnforce Mode = "Enforce"
+	// ModeAudit only validates the workload updates by a server-side dry-run and records them
+	// as events and in the CR status. Nothing is persisted.
+	ModeAudit Mode = "Audit"
+)
+
+// IsAudit returns true for the Audit mode in any case.
+func (m Mode) IsAudit() bool {
+	return strings.EqualFold(string(m), string(ModeAudit))
+}
+
+// RolloutPolicy defines when configuration changes are rolled out to instrumented workloads.
+// +kubebuilder:validation:Enum=Immediate;OnNextRollout;Manual
+type RolloutPolicy string
+
+const (
+	// RolloutImmediate updates the workloads as soon as the configuration changes.
+	RolloutImmediate RolloutPolicy = "Immediate"
+	// RolloutOnNextRollout applies the configuration when the pod template of the workload changes for another reason.
+	RolloutOnNextRollout RolloutPolicy = "OnNextRollout"
+	// RolloutManual applies the configuration when the workload is annotated with
+	// instrumentation.opentelemetry.io/apply-pending=true.
+	RolloutManual RolloutPolicy = "Manual"
+)
+
 // OpenTelemetryInstrumentationSpec defines the desired state of OpenTelemetryInstrumentation
 type OpenTelemetryInstrumentationSpec struct {
-	OTLPEndpoint       string            `json:"OTLPEndpoint,omitempty"`
-	JavaagentImage     string            `json:"javaagentImage,omitempty"`
-	TracesSampler      string            `json:"tracesSampler,omitempty"`
-	TracesSamplerArg   string            `json:"tracesSamplerArg,omitempty"`
+	OTLPEndpoint     string `json:"OTLPEndpoint,omitempty"`
+	JavaagentImage   string `json:"javaagentImage,omitempty"`
+	TracesSampler    string `json:"tracesSampler,omitempty"`
+	TracesSamplerArg string `json:"tracesSamplerArg,omitempty"`
+	// ResourceAttributes are added to OTEL_RESOURCE_ATTRIBUTES. Values are Go text/template
+	// expressions evaluated against .Workload (Name, Kind, Labels, Annotations),
+	// .Namespace (Name, Labels, Annotations) and .Container (Name, Image),
+	// e.g. {{ index .Namespace.Labels "env" }}.
 	ResourceAttributes map[string]string `json:"resourceAttributes,omitempty"`
+	// WorkloadSelector instruments the workloads in the namespace whose labels match the selector.
+	// Explicit opt-in labels and annotations take precedence over the selectors.
+	WorkloadSelector *metav1.LabelSelector `json:"workloadSelector,omitempty"`
+	// NamespaceSelector restricts the selector based instrumentation to namespaces whose labels match the selector.
+	// If only NamespaceSelector is set, all workloads in a matching namespace are instrumented.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Mode is either Enforce (default) or Audit, the lowercase enforce and audit are accepted as well.
+	Mode Mode `json:"mode,omitempty"`
+	// Paused stops all changes of the workloads in the namespace: no injections, cleanups
+	// or configuration updates. The workloads are reconciled once the CR is unpaused.
+	Paused bool `json:"paused,omitempty"`
+	// RolloutPolicy is either Immediate (default), OnNextRollout or Manual. It applies to configuration
+	// changes of already instrumented workloads, enabling and disabling the instrumentation is always immediate.
+	RolloutPolicy RolloutPolicy `json:"rolloutPolicy,omitempty"`
+
+	// InitContainerResources are the resources of the init container copying the agent.
+	InitContainerResources *corev1.ResourceRequirements `json:"initContainerResources,omitempty"`
+	// InitContainerSecurityContext is the security context of the init container copying the agent.
+	InitContainerSecurityContext *corev1.SecurityContext `json:"initContainerSecurityContext,omitempty"`
+}
+
+// WorkloadReference identifies a workload in the namespace of the CR.
+type WorkloadReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// AuditChange is a workload update the operator would make if it was not in the audit mode.
+type AuditChange struct {
+	Workload WorkloadReference `json:"workload"`
+	// Action is either Inject or Clean.
+	Action string `json:"action"`
+	// Diff summarizes the pod template changes, one change per line.
+	Diff string `json:"diff,omitempty"`
+	// Error is set when the server-side dry-run rejected the update.
+	Error string `json:"error,omitempty"`
+	// Time when the change was first recorded.
+	Time metav1.Time `json:"time"`
+}
+
+// WorkloadFailure is a workload whose instrumentation was rolled back because its pods failed.
+type WorkloadFailure struct {
+	Workload WorkloadReference `json:"workload"`
+	// Message describes the failure, e.g. CrashLoopBackOff, OOMKilled or ProgressDeadlineExceeded.
+	Message string `json:"message"`
+	// Time when the failure was first recorded.
+	Time metav1.Time `json:"time"`
 }
 
 // OpenTelemetryInstrumentationStatus defines the observed state of OpenTelemetryInstrumentation
 type OpenTelemetryInstrumentationStatus struct {
+	// Conditions describe the state of the instrumentation configuration.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// MatchedWorkloads lists the workloads selected by the workload and namespace selectors.
+	MatchedWorkloads []WorkloadReference `json:"matchedWorkloads,omitempty"`
+	// AuditChanges lists the pending workload updates in the audit mode.
+	AuditChanges []AuditChange `json:"auditChanges,omitempty"`
+	// StaleWorkloads lists the workloads running an outdated configuration because of the rollout policy.
+	StaleWorkloads []WorkloadReference `json:"staleWorkloads,omitempty"`
+	// Failures lists the workloads whose instrumentation was rolled back. The workloads are blocked
+	// from the instrumentation until the instrumentation.opentelemetry.io/blocked annotation is removed.
+	Failures []WorkloadFailure `json:"failures,omitempty"`
 }
 
 //+kubebuilder:object:root=true
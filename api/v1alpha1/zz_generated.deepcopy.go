@@ -22,16 +22,35 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditChange) DeepCopyInto(out *AuditChange) {
+	*out = *in
+	out.Workload = in.Workload
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditChange.
+func (in *AuditChange) DeepCopy() *AuditChange {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OpenTelemetryInstrumentation) DeepCopyInto(out *OpenTelemetryInstrumentation) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenTelemetryInstrumentation.
@@ -94,6 +113,26 @@ func (in *OpenTelemetryInstrumentationSpec) DeepCopyInto(out *OpenTelemetryInstr
 			(*out)[key] = val
 		}
 	}
+	if in.WorkloadSelector != nil {
+		in, out := &in.WorkloadSelector, &out.WorkloadSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InitContainerResources != nil {
+		in, out := &in.InitContainerResources, &out.InitContainerResources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InitContainerSecurityContext != nil {
+		in, out := &in.InitContainerSecurityContext, &out.InitContainerSecurityContext
+		*out = new(corev1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenTelemetryInstrumentationSpec.
@@ -109,6 +148,37 @@ func (in *OpenTelemetryInstrumentationSpec) DeepCopy() *OpenTelemetryInstrumenta
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OpenTelemetryInstrumentationStatus) DeepCopyInto(out *OpenTelemetryInstrumentationStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MatchedWorkloads != nil {
+		in, out := &in.MatchedWorkloads, &out.MatchedWorkloads
+		*out = make([]WorkloadReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.AuditChanges != nil {
+		in, out := &in.AuditChanges, &out.AuditChanges
+		*out = make([]AuditChange, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StaleWorkloads != nil {
+		in, out := &in.StaleWorkloads, &out.StaleWorkloads
+		*out = make([]WorkloadReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Failures != nil {
+		in, out := &in.Failures, &out.Failures
+		*out = make([]WorkloadFailure, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenTelemetryInstrumentationStatus.
@@ -120,3 +190,35 @@ func (in *OpenTelemetryInstrumentationStatus) DeepCopy() *OpenTelemetryInstrumen
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadFailure) DeepCopyInto(out *WorkloadFailure) {
+	*out = *in
+	out.Workload = in.Workload
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadFailure.
+func (in *WorkloadFailure) DeepCopy() *WorkloadFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadReference) DeepCopyInto(out *WorkloadReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadReference.
+func (in *WorkloadReference) DeepCopy() *WorkloadReference {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadReference)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,253 @@
+// Command otel-inst-fn is a KRM function injecting the instrumentation into the workloads at build time.
+// It reads a ResourceList whose functionConfig is an OpenTelemetryInstrumentation from stdin and writes
+// the changed ResourceList to stdout. The workloads are instrumented with the semantics of the operator:
+// the opt-in labels and annotations of the pod templates, workloads and Namespace objects of the list,
+// then the selectors of the CR. The workloads which are not enabled, blocked or in excluded namespaces are cleaned.
+// The opt-in keys, excluded namespaces and instrumentation defaults are set by the flags like in the operator,
+// or by the OperatorConfig file of the operator passed by --config, the flags set on the command line take precedence.
+//
+// With --instrumentation the function reads and writes a plain multi-document YAML stream instead,
+// e.g. as a Helm post-renderer:
+//
+//	helm install app ./chart --post-renderer otel-inst-fn --post-renderer-args --instrumentation=cr.yaml
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/manifests"
+)
+
+// options are the operator settings the workloads are rendered with.
+type options struct {
+	policy   inject.Policy
+	defaults configv1alpha1.InstrumentationDefaults
+}
+
+func main() {
+	opts := options{policy: inject.Policy{OptIn: inject.DefaultOptIn()}}
+	var excludedNamespaces, configFile string
+	instrumentationFile := flag.String("instrumentation", "", "The OpenTelemetryInstrumentation CR, "+
+		"a plain YAML stream is read from stdin instead of a ResourceList.")
+	flag.StringVar(&opts.policy.OptIn.Label, "instrumentation-label", opts.policy.OptIn.Label,
+		"The label which enables (true, enabled) or disables (false, disabled) the instrumentation "+
+			"on a pod template, workload or namespace. Empty string disables the label.")
+	flag.StringVar(&opts.policy.OptIn.Annotation, "instrumentation-annotation", opts.policy.OptIn.Annotation,
+		"The annotation which enables (true, enabled) or disables (false, disabled) the instrumentation "+
+			"on a pod template, workload or namespace. Empty string disables the annotation.")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", strings.Join(inject.DefaultExcludedNamespaces, ","),
+		"Comma separated glob patterns of namespaces which are never instrumented, e.g. kube-*,cert-manager. "+
+			"The instrumentation is removed from workloads in these namespaces.")
+	flag.StringVar(&configFile, "config", "",
+		"The OperatorConfig file of the operator, its instrumentation defaults, label keys and excluded namespaces "+
+			"replace the flags not set on the command line.")
+	flag.Parse()
+	opts.policy.ExcludedNamespaces = inject.SplitList(excludedNamespaces)
+	if configFile != "" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		var err error
+		if opts, err = opts.withConfigFile(configFile, set); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	}
+
+	run := func(stdin io.Reader, stdout io.Writer) error {
+		return runResourceList(opts, stdin, stdout)
+	}
+	if *instrumentationFile != "" {
+		run = func(stdin io.Reader, stdout io.Writer) error {
+			return runStream(opts, *instrumentationFile, stdin, stdout)
+		}
+	}
+	if err := run(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// withConfigFile returns the options overridden by the settings of the OperatorConfig file,
+// the flags set on the command line, by the names in explicit, take precedence over the file.
+func (o options) withConfigFile(path string, explicit map[string]bool) (options, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return o, err
+	}
+	defer f.Close()
+	config, err := manifests.ReadConfig(f)
+	if err != nil {
+		return o, fmt.Errorf("%s: %w", path, err)
+	}
+	o.defaults = config.Instrumentation
+	o.policy = o.policy.WithConfig(config, explicit)
+	return o, nil
+}
+
+// runStream renders a plain YAML stream by the CR of the file.
+func runStream(opts options, instrumentationFile string, stdin io.Reader, stdout io.Writer) error {
+	f, err := os.Open(instrumentationFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	instrumentation, err := manifests.ReadInstrumentation(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", instrumentationFile, err)
+	}
+	items, err := manifests.Read(stdin)
+	if err != nil {
+		return err
+	}
+	if !instrumentation.Spec.Paused && !instrumentation.Spec.Mode.IsAudit() {
+		if results := render(opts, items, instrumentation); len(results) > 0 {
+			return fmt.Errorf("%d workloads could not be rendered: %v", len(results), results)
+		}
+	}
+	return manifests.Write(stdout, items)
+}
+
+// runResourceList renders the ResourceList by the CR of its functionConfig.
+func runResourceList(opts options, stdin io.Reader, stdout io.Writer) error {
+	objs, err := manifests.Read(stdin)
+	if err != nil {
+		return err
+	}
+	if len(objs) != 1 || objs[0].GetKind() != "ResourceList" {
+		return fmt.Errorf("expected a ResourceList on stdin")
+	}
+	list := objs[0]
+
+	items, err := listItems(list)
+	if err != nil {
+		return err
+	}
+	config, found, err := unstructured.NestedMap(list.Object, "functionConfig")
+	if err != nil || !found {
+		return fmt.Errorf("functionConfig OpenTelemetryInstrumentation is required")
+	}
+	instrumentation, err := manifests.ToInstrumentation(&unstructured.Unstructured{Object: config})
+	if err != nil {
+		return fmt.Errorf("functionConfig: %w", err)
+	}
+
+	var results []interface{}
+	if !instrumentation.Spec.Paused && !instrumentation.Spec.Mode.IsAudit() {
+		results = render(opts, items, instrumentation)
+	}
+
+	content := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		content = append(content, item.Object)
+	}
+	list.Object["items"] = content
+	if len(results) > 0 {
+		list.Object["results"] = results
+	}
+	if err := manifests.Write(stdout, []*unstructured.Unstructured{list}); err != nil {
+		return err
+	}
+	if len(results) > 0 {
+		return fmt.Errorf("%d workloads could not be rendered", len(results))
+	}
+	return nil
+}
+
+// render injects or cleans the supported workloads of the list by the policy and the defaults of the options,
+// the failures are returned as results.
+func render(opts options, items []*unstructured.Unstructured, instrumentation *v1alpha1.OpenTelemetryInstrumentation) []interface{} {
+	defaultNamespace := instrumentation.Namespace
+	if defaultNamespace == "" {
+		defaultNamespace = metav1.NamespaceDefault
+	}
+	namespaces := map[string]metav1.ObjectMeta{}
+	for _, item := range items {
+		if item.GetKind() == "Namespace" && item.GetAPIVersion() == "v1" {
+			namespaces[item.GetName()] = metav1.ObjectMeta{Name: item.GetName(), Labels: item.GetLabels(), Annotations: item.GetAnnotations()}
+		}
+	}
+
+	var results []interface{}
+	spec := inject.WithDefaults(instrumentation.Spec, opts.defaults)
+	for _, item := range items {
+		if !manifests.Supported(item) {
+			continue
+		}
+		namespace := item.GetNamespace()
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+		ns, ok := namespaces[namespace]
+		if !ok {
+			ns = metav1.ObjectMeta{Name: namespace}
+		}
+
+		var err error
+		enabled := manifests.Enabled(item, ns, spec, opts.policy)
+		if enabled {
+			_, err = manifests.Inject(item, ns, spec)
+		} else {
+			_, err = manifests.Clean(item)
+		}
+		if err == nil {
+			markBuildTime(item, enabled)
+		}
+		if err != nil {
+			results = append(results, map[string]interface{}{
+				"message":  err.Error(),
+				"severity": "error",
+				"resourceRef": map[string]interface{}{
+					"apiVersion": item.GetAPIVersion(),
+					"kind":       item.GetKind(),
+					"name":       item.GetName(),
+					"namespace":  item.GetNamespace(),
+				},
+			})
+		}
+	}
+	return results
+}
+
+// markBuildTime records the build-time injection on the workload, the operator does not touch the workload.
+func markBuildTime(obj *unstructured.Unstructured, injected bool) {
+	annotations := obj.GetAnnotations()
+	if _, marked := annotations[inject.BuildTimeAnnotation]; marked == injected {
+		return
+	}
+	if injected {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[inject.BuildTimeAnnotation] = "true"
+	} else {
+		delete(annotations, inject.BuildTimeAnnotation)
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// listItems returns the items of the ResourceList.
+func listItems(list *unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	content, _, err := unstructured.NestedSlice(list.Object, "items")
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*unstructured.Unstructured, 0, len(content))
+	for _, item := range content {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid ResourceList item %v", item)
+		}
+		items = append(items, &unstructured.Unstructured{Object: obj})
+	}
+	return items, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+var update = flag.Bool("update", false, "Update the golden files.")
+
+func TestRunResourceList(t *testing.T) {
+	opts, err := options{policy: inject.Policy{
+		OptIn:              inject.DefaultOptIn(),
+		ExcludedNamespaces: inject.DefaultExcludedNamespaces,
+	}}.withConfigFile("testdata/config.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		golden string
+		err    string
+	}{
+		{
+			name:   "policy and defaults",
+			input:  "resourcelist.yaml",
+			golden: "resourcelist.golden.yaml",
+		},
+		{
+			name:   "results",
+			input:  "resourcelist-error.yaml",
+			golden: "resourcelist-error.golden.yaml",
+			err:    "1 workloads could not be rendered",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := os.Open(filepath.Join("testdata", test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer input.Close()
+			var out bytes.Buffer
+			err = runResourceList(opts, input, &out)
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected the error %q, got %v", test.err, err)
+			}
+			assertGolden(t, filepath.Join("testdata", test.golden), out.Bytes())
+		})
+	}
+}
+
+func TestRunStream(t *testing.T) {
+	opts, err := options{policy: inject.Policy{
+		OptIn:              inject.DefaultOptIn(),
+		ExcludedNamespaces: inject.DefaultExcludedNamespaces,
+	}}.withConfigFile("testdata/config.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file keeps the default opt-in annotation and replaces the excluded namespaces
+	if opts.policy.OptIn.Annotation != inject.DefaultOptInAnnotation || len(opts.policy.ExcludedNamespaces) != 1 {
+		t.Fatalf("unexpected options %+v", opts.policy)
+	}
+	opts.policy.ExcludedNamespaces = inject.DefaultExcludedNamespaces
+
+	input, err := os.Open("testdata/stream.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	var out bytes.Buffer
+	if err := runStream(opts, "testdata/instrumentation.yaml", input, &out); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "testdata/stream.golden.yaml", out.Bytes())
+}
+
+// assertGolden compares the output with the golden file, go test -update rewrites the golden file.
+func assertGolden(t *testing.T, path string, actual []byte) {
+	t.Helper()
+	if *update {
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("%s does not match, run go test -update to update it:\n%s", path, actual)
+	}
+}
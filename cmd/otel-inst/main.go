@@ -0,0 +1,109 @@
+// Command otel-inst renders the instrumentation into workload manifests without a cluster.
+//
+//	otel-inst inject -f deploy.yaml --instrumentation cr.yaml [--diff]
+//	otel-inst clean -f deploy.yaml [--diff]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/manifests"
+)
+
+const usage = `Usage:
+  otel-inst inject -f <manifests> --instrumentation <cr> [--namespace <ns>] [--diff]
+  otel-inst clean -f <manifests> [--diff]
+
+The manifests are a multi-document YAML stream, - reads stdin. The supported workloads
+(Pod, Deployment, StatefulSet, DaemonSet, ReplicaSet, ReplicationController, Job, CronJob)
+are changed, the other objects are printed unchanged.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[1], os.Args[2:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	file := flags.String("f", "-", "The manifests to change, - reads stdin.")
+	diff := flags.Bool("diff", false, "Print only the changes of the workloads.")
+	var instrumentationFile, namespace string
+	if command == "inject" {
+		flags.StringVar(&instrumentationFile, "instrumentation", "", "The OpenTelemetryInstrumentation CR.")
+		flags.StringVar(&namespace, "namespace", "default", "The namespace of the workloads without a namespace.")
+	} else if command != "clean" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	_ = flags.Parse(args)
+
+	var instrumentation *v1alpha1.OpenTelemetryInstrumentation
+	if command == "inject" {
+		if instrumentationFile == "" {
+			return fmt.Errorf("--instrumentation is required")
+		}
+		f, err := os.Open(instrumentationFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if instrumentation, err = manifests.ReadInstrumentation(f); err != nil {
+			return fmt.Errorf("%s: %w", instrumentationFile, err)
+		}
+	}
+
+	in := stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	objs, err := manifests.Read(in)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	for _, obj := range objs {
+		var changes []string
+		if instrumentation != nil {
+			changes, err = manifests.Inject(obj, metav1.ObjectMeta{Name: namespace}, instrumentation.Spec)
+		} else {
+			changes, err = manifests.Clean(obj)
+		}
+		if err != nil {
+			return err
+		}
+		if *diff && len(changes) > 0 {
+			printDiff(stdout, obj, changes)
+		}
+	}
+	if *diff {
+		return nil
+	}
+	return manifests.Write(stdout, objs)
+}
+
+func printDiff(w io.Writer, obj *unstructured.Unstructured, changes []string) {
+	fmt.Fprintf(w, "%s/%s:\n", obj.GetKind(), obj.GetName())
+	for _, change := range changes {
+		fmt.Fprintf(w, "  %s\n", change)
+	}
+}
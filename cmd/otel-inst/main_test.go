@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the golden files.")
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		golden  string
+	}{
+		{
+			name:    "inject",
+			command: "inject",
+			args:    []string{"-f", "testdata/workloads.yaml", "--instrumentation", "testdata/instrumentation.yaml", "--namespace", "shop"},
+			golden:  "inject.golden.yaml",
+		},
+		{
+			name:    "inject diff",
+			command: "inject",
+			args:    []string{"-f", "testdata/workloads.yaml", "--instrumentation", "testdata/instrumentation.yaml", "--namespace", "shop", "--diff"},
+			golden:  "inject.diff.golden",
+		},
+		{
+			name:    "clean",
+			command: "clean",
+			args:    []string{"-f", "testdata/inject.golden.yaml"},
+			golden:  "clean.golden.yaml",
+		},
+		{
+			name:    "clean diff",
+			command: "clean",
+			args:    []string{"-f", "testdata/inject.golden.yaml", "--diff"},
+			golden:  "clean.diff.golden",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(test.command, test.args, nil, &out); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("testdata", test.golden), out.Bytes())
+		})
+	}
+}
+
+func TestCleanRestoresManifests(t *testing.T) {
+	var injected, cleaned bytes.Buffer
+	args := []string{"-f", "testdata/workloads.yaml", "--instrumentation", "testdata/instrumentation.yaml"}
+	if err := run("inject", args, nil, &injected); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("clean", []string{"-f", "-"}, &injected, &cleaned); err != nil {
+		t.Fatal(err)
+	}
+	var original bytes.Buffer
+	if err := run("clean", []string{"-f", "testdata/workloads.yaml"}, nil, &original); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original.Bytes(), cleaned.Bytes()) {
+		t.Errorf("expected the cleaned manifests to match the original ones:\n%s\ngot:\n%s", original.String(), cleaned.String())
+	}
+}
+
+// assertGolden compares the output with the golden file, go test -update rewrites the golden file.
+func assertGolden(t *testing.T, path string, actual []byte) {
+	t.Helper()
+	if *update {
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("%s does not match, run go test -update to update it:\n%s", path, actual)
+	}
+}
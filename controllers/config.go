@@ -0,0 +1,165 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+)
+
+// Settings holds the Options shared by the reconcilers. The Options are replaced when the configuration file changes.
+type Settings struct {
+	mu          sync.RWMutex
+	options     Options
+	subscribers []chan event.GenericEvent
+}
+
+// NewSettings creates the settings holding the options.
+func NewSettings(opts Options) *Settings {
+	return &Settings{options: opts}
+}
+
+// Options returns the current options.
+func (s *Settings) Options() Options {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.options
+}
+
+// Set replaces the options and notifies the subscribers.
+func (s *Settings) Set(opts Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.options = opts
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- event.GenericEvent{Object: &v1alpha1.OpenTelemetryInstrumentation{}}:
+		default:
+			// a change is already queued
+		}
+	}
+}
+
+// subscribe returns a channel receiving an event when the options change.
+func (s *Settings) subscribe() <-chan event.GenericEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan event.GenericEvent, 1)
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// WithConfig returns the options overridden by the settings of the configuration file. The flags set
+// on the command line take precedence over the file, explicit holds their names, e.g. excluded-namespaces.
+// The file takes precedence over the defaults of the flags.
+func (o Options) WithConfig(config *configv1alpha1.OperatorConfig, explicit map[string]bool) Options {
+	o.Defaults = config.Instrumentation
+	policy := o.policy().WithConfig(config, explicit)
+	o.OptIn, o.ExcludedNamespaces = policy.OptIn, policy.ExcludedNamespaces
+	if config.Mode != "" && !explicit["dry-run"] {
+		o.DryRun = config.Mode.IsAudit()
+	}
+	if config.MaxConcurrentRollouts != nil && !explicit["max-concurrent-rollouts"] {
+		o.MaxConcurrentRollouts = *config.MaxConcurrentRollouts
+	}
+	if config.MaxConcurrentRolloutsPerNamespace != nil && !explicit["max-concurrent-rollouts-per-namespace"] {
+		o.MaxConcurrentRolloutsPerNamespace = *config.MaxConcurrentRolloutsPerNamespace
+	}
+	return o
+}
+
+// ConfigReloader reloads the Settings when the configuration file changes. The directory of the file
+// is watched, a mounted ConfigMap is updated by replacing a symlink in the directory.
+type ConfigReloader struct {
+	// Path of the configuration file.
+	Path string
+	// Options are overridden by the configuration file, they hold the settings of the flags.
+	Options Options
+	// ExplicitFlags are the names of the flags set on the command line, they are not overridden by the file.
+	ExplicitFlags map[string]bool
+	Settings      *Settings
+}
+
+// Start watches the configuration file until the context is done. It implements manager.Runnable.
+func (r *ConfigReloader) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("config")
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(r.Path)); err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			logger.Error(err, "cannot watch the configuration file", "path", r.Path)
+		case <-watcher.Events:
+			changed, err := ioutil.ReadFile(r.Path)
+			if err != nil || bytes.Equal(content, changed) {
+				continue
+			}
+			config, err := decodeConfig(changed)
+			if err != nil {
+				logger.Error(err, "invalid configuration file, keeping the previous configuration", "path", r.Path)
+				continue
+			}
+			content = changed
+			r.Settings.Set(r.Options.WithConfig(config, r.ExplicitFlags))
+			logger.Info("configuration reloaded", "path", r.Path)
+		}
+	}
+}
+
+// NeedLeaderElection returns false, the settings are reloaded by all replicas.
+func (r *ConfigReloader) NeedLeaderElection() bool {
+	return false
+}
+
+// decodeConfig decodes the OperatorConfig file.
+func decodeConfig(content []byte) (*configv1alpha1.OperatorConfig, error) {
+	scheme := runtime.NewScheme()
+	if err := configv1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	config := &configv1alpha1.OperatorConfig{}
+	if err := runtime.DecodeInto(serializer.NewCodecFactory(scheme).UniversalDecoder(), content, config); err != nil {
+		return nil, fmt.Errorf("cannot decode the configuration file: %w", err)
+	}
+	return config, nil
+}
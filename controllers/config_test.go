@@ -0,0 +1,129 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestWithConfigMode(t *testing.T) {
+	tests := []struct {
+		name string
+		// dryRun is the value of the --dry-run flag, explicit if it was set on the command line
+		dryRun   bool
+		explicit bool
+		mode     v1alpha1.Mode
+		audit    bool
+	}{
+		{name: "not set"},
+		{name: "not set, dry-run flag", dryRun: true, explicit: true, audit: true},
+		{name: "enforce", mode: v1alpha1.ModeEnforce},
+		{name: "enforce, dry-run flag", dryRun: true, explicit: true, mode: v1alpha1.ModeEnforce, audit: true},
+		{name: "audit", mode: v1alpha1.ModeAudit, audit: true},
+		{name: "audit, dry-run=false flag", explicit: true, mode: v1alpha1.ModeAudit},
+		{name: "lowercase audit", mode: "audit", audit: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			explicit := map[string]bool{"dry-run": test.explicit}
+			opts := Options{DryRun: test.dryRun}.WithConfig(&configv1alpha1.OperatorConfig{Mode: test.mode}, explicit)
+			if opts.DryRun != test.audit {
+				t.Errorf("expected the audit mode %v, got %v", test.audit, opts.DryRun)
+			}
+		})
+	}
+}
+
+func TestWithConfigPrecedence(t *testing.T) {
+	label, annotation, rollouts, namespaceRollouts := "file-label", "file-annotation", 5, 2
+	config := &configv1alpha1.OperatorConfig{
+		InstrumentationLabel:              &label,
+		InstrumentationAnnotation:         &annotation,
+		ExcludedNamespaces:                []string{"file-ns"},
+		MaxConcurrentRollouts:             &rollouts,
+		MaxConcurrentRolloutsPerNamespace: &namespaceRollouts,
+	}
+	flags := Options{
+		OptIn:                             inject.OptIn{Label: "flag-label", Annotation: "flag-annotation"},
+		ExcludedNamespaces:                []string{"flag-ns"},
+		MaxConcurrentRollouts:             10,
+		MaxConcurrentRolloutsPerNamespace: 3,
+	}
+
+	opts := flags.WithConfig(config, nil)
+	if opts.OptIn.Label != label || opts.OptIn.Annotation != annotation {
+		t.Errorf("expected the opt-in keys of the file, got %+v", opts.OptIn)
+	}
+	if !reflect.DeepEqual(opts.ExcludedNamespaces, []string{"file-ns"}) {
+		t.Errorf("expected the excluded namespaces of the file, got %v", opts.ExcludedNamespaces)
+	}
+	if opts.MaxConcurrentRollouts != rollouts || opts.MaxConcurrentRolloutsPerNamespace != namespaceRollouts {
+		t.Errorf("expected the rollout limits of the file, got %d and %d", opts.MaxConcurrentRollouts, opts.MaxConcurrentRolloutsPerNamespace)
+	}
+
+	explicit := map[string]bool{
+		"instrumentation-label":                 true,
+		"instrumentation-annotation":            true,
+		"excluded-namespaces":                   true,
+		"max-concurrent-rollouts":               true,
+		"max-concurrent-rollouts-per-namespace": true,
+	}
+	opts = flags.WithConfig(config, explicit)
+	if !reflect.DeepEqual(opts, flags) {
+		t.Errorf("expected the explicit flags to win, got %+v", opts)
+	}
+
+	// a flag explicitly set to its default value wins as well
+	opts = Options{}.WithConfig(config, map[string]bool{"max-concurrent-rollouts": true})
+	if opts.MaxConcurrentRollouts != 0 || opts.MaxConcurrentRolloutsPerNamespace != namespaceRollouts {
+		t.Errorf("expected only the explicit rollout limit to win, got %d and %d", opts.MaxConcurrentRollouts, opts.MaxConcurrentRolloutsPerNamespace)
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	config, err := decodeConfig([]byte(`
+apiVersion: config.opentelemetry.io/v1alpha1
+kind: OperatorConfig
+instrumentation:
+  images:
+    java: agent:1
+instrumentationLabel: ""
+mode: Audit
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{OptIn: inject.DefaultOptIn()}.WithConfig(config, nil)
+	if opts.Defaults.Images[configv1alpha1.LanguageJava] != "agent:1" {
+		t.Errorf("expected the java image agent:1, got %v", opts.Defaults.Images)
+	}
+	if opts.OptIn.Label != "" || opts.OptIn.Annotation == "" {
+		t.Errorf("expected only the label to be disabled, got %+v", opts.OptIn)
+	}
+	if !opts.DryRun {
+		t.Error("expected the audit mode")
+	}
+
+	if _, err := decodeConfig([]byte("kind: Unknown")); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
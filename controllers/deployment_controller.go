@@ -19,83 +19,227 @@ package controllers
 import (
 	"context"
 	"fmt"
-	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
-	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
 )
 
 type DeploymentControllerReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// APIReader reads the pods of instrumented Deployments directly from the API server.
+	APIReader client.Reader
+	Settings  *Settings
+	// Reconciler configures the concurrency and rate limiting of the reconciler.
+	Reconciler ReconcilerOptions
+	// Gate limits the rollouts started by the parallel reconciliations and the Sweeper.
+	Gate *RolloutGate
 }
 
 //+kubebuilder:rbac:groups=opentelemetry.io,resources=opentelemetryinstrumentations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=opentelemetry.io,resources=opentelemetryinstrumentations/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=opentelemetry.io,resources=opentelemetryinstrumentations/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.9.2/pkg/reconcile
-func (r *DeploymentControllerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-	fmt.Println("Deployment reconcile: " + req.Namespace + "/" + req.Name)
+func (r *DeploymentControllerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := startReconcileSpan(ctx, "Deployment", req)
+	defer func() { endSpan(span, err) }()
+	log.FromContext(ctx).V(1).Info("reconciling")
+	opts := r.Settings.Options()
 
 	dep := &v1.Deployment{}
-	err := r.Client.Get(ctx, req.NamespacedName, dep)
+	err = r.Client.Get(ctx, req.NamespacedName, dep)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
+	if !opts.ownsWorkload(dep) {
+		log.FromContext(ctx).V(1).Info("workload is owned by another operator instance or instrumented at build time",
+			"instance", dep.Annotations[InstanceLabel])
+		return ctrl.Result{}, nil
+	}
 
-	ns := &corev1.Namespace{}
-	if err := r.Client.Get(ctx, types.NamespacedName{
-		Name: req.Namespace,
-	}, ns); err != nil {
+	instrumentation, err := getInstrumentation(ctx, r.Client, req.Namespace)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	ctx = withInstrumentation(ctx, instrumentation)
+	if isPaused(ctx, instrumentation) {
+		return ctrl.Result{}, nil
+	}
+	if instrumentation != nil {
+		if _, err := validate(instrumentation.Spec); err != nil {
+			// keep the workload as it is until the configuration is fixed, the error is reported in the CR status
+			log.FromContext(ctx).V(1).Info("instrumentation configuration is invalid", "error", err.Error())
+			return ctrl.Result{}, nil
+		}
+	}
+	writer := newWorkloadWriter(r.Client, r.Recorder, r.Gate, opts, instrumentation)
 
-	if inject.IsInstrumentationEnabled(javaInstrumentationLabel, dep.ObjectMeta, ns.ObjectMeta) {
-		instrumentation := &v1alpha1.OpenTelemetryInstrumentation{}
-		err := r.Client.Get(ctx, types.NamespacedName{
-			Namespace: req.Namespace,
-			Name:      "opentelemetry-instrumentation",
-		}, instrumentation)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				fmt.Println("opentelemetry-instrumentation CR does not exists in: " + req.Namespace)
-				return ctrl.Result{}, nil
-			}
+	if pattern, excluded := opts.ExcludedNamespace(req.Namespace); excluded {
+		if err := writer.cleanExcluded(ctx, dep, pattern); err != nil {
 			return ctrl.Result{}, err
 		}
+		return writer.result(), writer.updateStatus(ctx, instrumentation)
+	}
 
-		inject.InjectPod(dep.ObjectMeta, &(dep.Spec.Template.Spec), instrumentation.Spec)
-		if err := r.Client.Update(ctx, dep); err != nil {
-			return ctrl.Result{}, err
+	ns, err := getNamespace(ctx, r.Client, opts, req.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if ns == nil {
+		// the namespace is not cached, it does not match the namespace cache selector
+		log.FromContext(ctx).V(1).Info("namespace is not managed")
+		return ctrl.Result{}, nil
+	}
+
+	if err := writer.timeBox(ctx, dep); err != nil {
+		return ctrl.Result{}, err
+	}
+	if isInstrumentationEnabled(opts, ns, dep, instrumentation) {
+		if instrumentation == nil {
+			countError(reasonNoInstrumentation)
+			r.Recorder.Event(dep, corev1.EventTypeWarning, reasonNoInstrumentation,
+				fmt.Sprintf("Instrumentation is enabled, but the %s CR does not exist in the namespace", instrumentationName))
+			return ctrl.Result{}, nil
 		}
-	} else {
-		objectUpdated := inject.Clean(&dep.Spec.Template.Spec)
-		if objectUpdated {
-			fmt.Println("-----> Updating removed instance " + req.String())
-			if err := r.Client.Update(ctx, dep); err != nil {
+		if !writer.audit {
+			failure, err := instrumentationFailure(ctx, r.APIReader, dep)
+			if err != nil {
 				return ctrl.Result{}, err
 			}
+			if failure != "" {
+				if err := writer.rollback(ctx, dep, failure); err != nil {
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, writer.updateStatus(ctx, instrumentation)
+			}
+		}
+		if err := writer.inject(ctx, ns, dep, instrumentation); err != nil {
+			return ctrl.Result{}, err
+		}
+		if needsHealthCheck(dep) {
+			writer.requeue(healthCheckInterval)
+		}
+	} else {
+		if err := writer.clean(ctx, dep); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return writer.result(), writer.updateStatus(ctx, instrumentation)
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager. The Deployment is the only object the operator
+// changes, Namespace and instrumentation CR events are mapped to the Deployments of the namespace.
+// Status-only updates are ignored, the rollouts and health of instrumented Deployments are checked by requeueing.
+// All Deployments are reconciled when the settings are reloaded.
 func (r *DeploymentControllerReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1.Deployment{}).
-		Complete(r)
+	if err := r.Settings.setupIndexers(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&v1.Deployment{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.LabelChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		WithOptions(r.Reconciler.controllerOptions()).
+		Watches(&source.Kind{Type: &v1alpha1.OpenTelemetryInstrumentation{}}, handler.EnqueueRequestsFromMapFunc(r.instrumentationDeployments),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Channel{Source: r.Settings.subscribe()}, handler.EnqueueRequestsFromMapFunc(r.allDeployments))
+	if !r.Settings.Options().namespaceScoped() {
+		b = b.Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceDeployments),
+			builder.WithPredicates(predicate.Or(predicate.LabelChangedPredicate{}, predicate.AnnotationChangedPredicate{})))
+	}
+	return b.Complete(r)
+}
+
+// namespaceDeployments maps a Namespace event to the Deployments of the namespace.
+func (r *DeploymentControllerReconciler) namespaceDeployments(obj client.Object) []reconcile.Request {
+	return r.deploymentRequests(obj.GetName())
+}
+
+// allDeployments maps a settings change to all Deployments.
+func (r *DeploymentControllerReconciler) allDeployments(client.Object) []reconcile.Request {
+	return r.deploymentRequests(metav1.NamespaceAll)
+}
+
+// instrumentationDeployments maps an instrumentation CR event to the Deployments it can enable. A CR without
+// a workload selector maps to all Deployments of the namespace, they can be opted in by the namespace.
+// A CR with a workload selector maps to the Deployments referencing the CR and the Deployments matching
+// the selector, or to all Deployments if the namespace opts in.
+func (r *DeploymentControllerReconciler) instrumentationDeployments(obj client.Object) []reconcile.Request {
+	instrumentation, ok := obj.(*v1alpha1.OpenTelemetryInstrumentation)
+	if !ok || obj.GetName() != instrumentationName {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(instrumentation.Spec.WorkloadSelector)
+	if instrumentation.Spec.WorkloadSelector == nil || err != nil || r.namespaceEnabled(obj.GetNamespace()) {
+		return r.deploymentRequests(obj.GetNamespace())
+	}
+	requests := r.deploymentRequests(obj.GetNamespace(), client.MatchingFields{indexInstrumentation: obj.GetName()})
+	for _, request := range r.deploymentRequests(obj.GetNamespace(), client.MatchingLabelsSelector{Selector: selector}) {
+		if !containsRequest(requests, request) {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// namespaceEnabled returns true if the namespace enables the instrumentation by the opt-in label or annotation,
+// or it cannot be read.
+func (r *DeploymentControllerReconciler) namespaceEnabled(name string) bool {
+	opts := r.Settings.Options()
+	ns, err := getNamespace(context.Background(), r.Client, opts, name)
+	if err != nil || ns == nil {
+		return err != nil
+	}
+	return opts.OptIn.IsInstrumentationEnabled(ns.ObjectMeta)
+}
+
+// deploymentRequests returns the requests of the Deployments in the namespace ordered by the rollout priority.
+func (r *DeploymentControllerReconciler) deploymentRequests(namespace string, opts ...client.ListOption) []reconcile.Request {
+	deps := &v1.DeploymentList{}
+	if err := r.Client.List(context.Background(), deps, append(opts, client.InNamespace(namespace))...); err != nil {
+		ctrl.Log.WithName("deployment-mapper").Error(err, "cannot list deployments", "workloadNamespace", namespace)
+		return nil
+	}
+	sortByRolloutPriority(deps.Items)
+	requests := make([]reconcile.Request, 0, len(deps.Items))
+	for i := range deps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&deps.Items[i])})
+	}
+	return requests
+}
+
+func containsRequest(requests []reconcile.Request, request reconcile.Request) bool {
+	for _, r := range requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
 }
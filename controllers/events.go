@@ -0,0 +1,38 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+// Event reasons are part of the operator interface, alerts are built on them. Do not rename them.
+const (
+	// reasonInjected is emitted when the instrumentation was injected into or updated in a workload.
+	reasonInjected = "Injected"
+	// reasonCleaned is emitted when the instrumentation was removed from a workload.
+	reasonCleaned = "Cleaned"
+	// reasonNoInstrumentation is emitted when a workload is enabled, but its namespace has no instrumentation CR.
+	reasonNoInstrumentation = "NoInstrumentation"
+	// reasonConflict is emitted when a workload update failed on a conflict, the update is retried.
+	reasonConflict = "Conflict"
+	// reasonInvalidConfiguration is emitted when the instrumentation configuration cannot be applied.
+	reasonInvalidConfiguration = "InvalidConfiguration"
+	// reasonRolledBack is emitted when the instrumentation of a failing workload was removed.
+	reasonRolledBack = "RolledBack"
+	// reasonOrphaned is emitted when the sweep found an instrumented workload not enabled by any instrumentation CR.
+	reasonOrphaned = "Orphaned"
+	// reasonAudit and reasonAuditFailed report the changes of a workload in the audit mode.
+	reasonAudit       = "Audit"
+	reasonAuditFailed = "AuditFailed"
+)
@@ -0,0 +1,116 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// annotationBlocked is set on a workload whose instrumentation was rolled back.
+	// The workload is not instrumented again until the annotation is removed.
+	annotationBlocked = inject.BlockedAnnotation
+	// annotationRolloutGeneration is the generation of the Deployment rolled out by the operator injecting
+	// the instrumentation, only the failures of this rollout roll the instrumentation back.
+	annotationRolloutGeneration = "instrumentation.opentelemetry.io/rollout-generation"
+
+	// annotationRevision is the revision of the Deployment and its ReplicaSets set by the deployment controller.
+	annotationRevision = "deployment.kubernetes.io/revision"
+
+	// healthCheckInterval is the delay between the health checks of an instrumented Deployment during its rollout.
+	healthCheckInterval = 30 * time.Second
+)
+
+// operatorRollout returns true if the current pod template of the Deployment was rolled out by the operator
+// injecting the instrumentation, and not by a later change of the user.
+func operatorRollout(dep *v1.Deployment) bool {
+	generation, err := strconv.ParseInt(dep.Annotations[annotationRolloutGeneration], 10, 64)
+	return err == nil && generation == dep.Generation
+}
+
+// needsHealthCheck returns true if the pods of the instrumented Deployment should be checked,
+// during the rollout the operator started.
+func needsHealthCheck(dep *v1.Deployment) bool {
+	return inject.IsInjected(&dep.Spec.Template.Spec) && operatorRollout(dep) && rolloutInProgress(dep)
+}
+
+// instrumentationFailure returns the description of a failure of the rollout injecting the instrumentation,
+// empty if the rollout is healthy or it was not started by the operator. Only the pods of the new ReplicaSet
+// of the rollout are checked. The ReplicaSets and pods are read by the reader to avoid caching them.
+func instrumentationFailure(ctx context.Context, reader client.Reader, dep *v1.Deployment) (string, error) {
+	if !inject.IsInjected(&dep.Spec.Template.Spec) || !operatorRollout(dep) || dep.Status.ObservedGeneration < dep.Generation {
+		return "", nil
+	}
+	for _, c := range dep.Status.Conditions {
+		if c.Type == v1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == reasonProgressDeadlineExceeded {
+			return fmt.Sprintf("ProgressDeadlineExceeded: %s", c.Message), nil
+		}
+	}
+	if !rolloutInProgress(dep) {
+		return "", nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	replicaSets := &v1.ReplicaSetList{}
+	if err := reader.List(ctx, replicaSets, client.InNamespace(dep.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return "", err
+	}
+	hash := ""
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if metav1.IsControlledBy(rs, dep) && rs.Annotations[annotationRevision] == dep.Annotations[annotationRevision] {
+			hash = rs.Labels[v1.DefaultDeploymentUniqueLabelKey]
+		}
+	}
+	if hash == "" {
+		// the new ReplicaSet has not been created yet
+		return "", nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := reader.List(ctx, pods, client.InNamespace(dep.Namespace), client.MatchingLabelsSelector{Selector: selector},
+		client.MatchingLabels{v1.DefaultDeploymentUniqueLabelKey: hash}); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if !inject.IsInjected(&pod.Spec) {
+			continue
+		}
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				return fmt.Sprintf("CrashLoopBackOff: container %s of pod %s", cs.Name, pod.Name), nil
+			}
+			if cs.LastTerminationState.Terminated != nil && cs.LastTerminationState.Terminated.Reason == "OOMKilled" {
+				return fmt.Sprintf("OOMKilled: container %s of pod %s", cs.Name, pod.Name), nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestInstrumentationFailure(t *testing.T) {
+	labels := map[string]string{"app": "backend"}
+	deployment := func(generation int64, rolledOut string, reason string) *v1.Deployment {
+		dep := &v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "backend",
+				Namespace:   "shop",
+				UID:         "backend-uid",
+				Generation:  generation,
+				Annotations: map[string]string{annotationRolloutGeneration: rolledOut, annotationRevision: "2"},
+			},
+			Spec: v1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+			Status: v1.DeploymentStatus{
+				ObservedGeneration: generation,
+				Conditions: []v1.DeploymentCondition{{
+					Type:    v1.DeploymentProgressing,
+					Status:  corev1.ConditionTrue,
+					Reason:  reason,
+					Message: "ReplicaSet backend-new is progressing",
+				}},
+			},
+		}
+		if reason == reasonProgressDeadlineExceeded {
+			dep.Status.Conditions[0].Status = corev1.ConditionFalse
+			dep.Status.Conditions[0].Message = "ReplicaSet backend-new has timed out progressing"
+		}
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+		if err := inject.InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, &dep.Spec.Template.Spec,
+			v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+			t.Fatal(err)
+		}
+		return dep
+	}
+	replicaSet := func(dep *v1.Deployment, hash, revision string) *v1.ReplicaSet {
+		rsLabels := map[string]string{"app": "backend", v1.DefaultDeploymentUniqueLabelKey: hash}
+		return &v1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            "backend-" + hash,
+			Namespace:       "shop",
+			Labels:          rsLabels,
+			Annotations:     map[string]string{annotationRevision: revision},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(dep, v1.SchemeGroupVersion.WithKind("Deployment"))},
+		}}
+	}
+	pod := func(dep *v1.Deployment, hash string, status corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backend-" + hash + "-1",
+				Namespace: "shop",
+				Labels:    map[string]string{"app": "backend", v1.DefaultDeploymentUniqueLabelKey: hash},
+			},
+			Spec:   dep.Spec.Template.Spec,
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+		}
+	}
+	crashLoop := corev1.ContainerStatus{Name: "app", State: corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+	}}
+	oomKilled := corev1.ContainerStatus{Name: "app", LastTerminationState: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled"},
+	}}
+	healthy := corev1.ContainerStatus{Name: "app", Ready: true}
+
+	tests := []struct {
+		name    string
+		dep     *v1.Deployment
+		old     corev1.ContainerStatus
+		new     corev1.ContainerStatus
+		failure string
+	}{
+		{
+			name: "healthy",
+			dep:  deployment(2, "2", "ReplicaSetUpdated"),
+			old:  healthy,
+			new:  healthy,
+		},
+		{
+			name:    "progress deadline exceeded",
+			dep:     deployment(2, "2", reasonProgressDeadlineExceeded),
+			old:     healthy,
+			new:     healthy,
+			failure: "ProgressDeadlineExceeded: ReplicaSet backend-new has timed out progressing",
+		},
+		{
+			name:    "crash loop",
+			dep:     deployment(2, "2", "ReplicaSetUpdated"),
+			old:     healthy,
+			new:     crashLoop,
+			failure: "CrashLoopBackOff: container app of pod backend-new-1",
+		},
+		{
+			name:    "OOM killed",
+			dep:     deployment(2, "2", "ReplicaSetUpdated"),
+			old:     healthy,
+			new:     oomKilled,
+			failure: "OOMKilled: container app of pod backend-new-1",
+		},
+		{
+			name: "OOM killed pod of the previous replica set",
+			dep:  deployment(2, "2", "ReplicaSetUpdated"),
+			old:  oomKilled,
+			new:  healthy,
+		},
+		{
+			name: "completed rollout",
+			dep:  deployment(2, "2", reasonNewReplicaSetAvailable),
+			old:  healthy,
+			new:  crashLoop,
+		},
+		{
+			name: "crash loop of a rollout of the user",
+			dep:  deployment(3, "2", "ReplicaSetUpdated"),
+			old:  healthy,
+			new:  crashLoop,
+		},
+		{
+			name: "progress deadline of a rollout of the user",
+			dep:  deployment(3, "2", reasonProgressDeadlineExceeded),
+			old:  healthy,
+			new:  healthy,
+		},
+		{
+			name: "not rolled out by the operator",
+			dep:  deployment(2, "", "ReplicaSetUpdated"),
+			old:  healthy,
+			new:  oomKilled,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(
+				test.dep,
+				replicaSet(test.dep, "old", "1"),
+				replicaSet(test.dep, "new", "2"),
+				pod(test.dep, "old", test.old),
+				pod(test.dep, "new", test.new),
+			).Build()
+			failure, err := instrumentationFailure(context.Background(), c, test.dep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failure != test.failure {
+				t.Errorf("expected the failure %q, got %q", test.failure, failure)
+			}
+		})
+	}
+}
+
+func TestInjectRecordsRolloutGeneration(t *testing.T) {
+	dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop", Generation: 4}}
+	dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(dep.DeepCopy()).Build()
+	instrumentation := &v1alpha1.OpenTelemetryInstrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: instrumentationName, Namespace: "shop"},
+		Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"},
+	}
+	ctx := context.Background()
+	w := newWorkloadWriter(c, record.NewFakeRecorder(10), NewRolloutGate(), Options{}, instrumentation)
+	if err := w.inject(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, dep, instrumentation); err != nil {
+		t.Fatal(err)
+	}
+	stored := &v1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(dep), stored); err != nil {
+		t.Fatal(err)
+	}
+	if generation := stored.Annotations[annotationRolloutGeneration]; generation != "5" {
+		t.Errorf("expected the rollout generation 5, got %q", generation)
+	}
+
+	if err := w.clean(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(dep), stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Annotations[annotationRolloutGeneration]; ok {
+		t.Errorf("expected the rollout generation to be removed, got %v", stored.Annotations)
+	}
+}
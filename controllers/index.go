@@ -0,0 +1,111 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// indexInstrumentation indexes the Deployments by the name of the instrumentation CR they reference.
+	indexInstrumentation = "instrumentation"
+
+	// ManagedLabel is the marker label recommended for restricting the cache to the managed workloads.
+	ManagedLabel = "instrumentation.opentelemetry.io/managed"
+
+	// InstanceLabel assigns an instrumentation CR to an operator instance. The annotation with the same key
+	// records the operator instance which owns the instrumentation of a workload.
+	InstanceLabel = "instrumentation.opentelemetry.io/instance"
+	// DefaultInstanceID is the owner of the workloads instrumented by the operator instance without an ID.
+	DefaultInstanceID = "default"
+)
+
+// instrumentationReferences returns the instrumentation CR referenced by the Deployment. A Deployment
+// references the CR if it is instrumented, opted in or out explicitly, or carries any state of the operator.
+func (o Options) instrumentationReferences(obj client.Object) []string {
+	dep, ok := obj.(*v1.Deployment)
+	if !ok {
+		return nil
+	}
+	if inject.IsInjected(&dep.Spec.Template.Spec) {
+		return []string{instrumentationName}
+	}
+	if _, ok := o.OptIn.Lookup(dep.Spec.Template.ObjectMeta, dep.ObjectMeta); ok {
+		return []string{instrumentationName}
+	}
+	if _, canary := inject.CanaryPercent(dep.Spec.Template.ObjectMeta); canary {
+		return []string{instrumentationName}
+	}
+	for _, annotation := range []string{annotationBlocked, annotationPendingChanges, annotationExpiresAt, annotationTTL, annotationSessionExpired} {
+		if _, ok := dep.Annotations[annotation]; ok {
+			return []string{instrumentationName}
+		}
+	}
+	return nil
+}
+
+// CacheSelectors restricts the cached Deployments and Namespaces to the objects matching the label selectors.
+// Empty selectors cache all objects. Only the instrumentation CRs of the operator instance are cached.
+func CacheSelectors(workloadSelector, namespaceSelector, instanceID string) (cache.SelectorsByObject, error) {
+	workloads, err := labels.Parse(workloadSelector)
+	if err != nil {
+		return nil, fmt.Errorf("workload selector: %w", err)
+	}
+	namespaces, err := labels.Parse(namespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("namespace selector: %w", err)
+	}
+	instances, err := instanceSelector(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return cache.SelectorsByObject{
+		&v1.Deployment{}:                         {Label: workloads},
+		&corev1.Namespace{}:                      {Label: namespaces},
+		&v1alpha1.OpenTelemetryInstrumentation{}: {Label: instances},
+	}, nil
+}
+
+// instanceSelector selects the instrumentation CRs of the operator instance, the CRs without
+// the instance label for the instance without an ID.
+func instanceSelector(instanceID string) (labels.Selector, error) {
+	selector := "!" + InstanceLabel
+	if instanceID != "" {
+		selector = InstanceLabel + "=" + instanceID
+	}
+	instances, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("instance ID: %w", err)
+	}
+	return instances, nil
+}
+
+// setupIndexers registers the field indexers of the workloads.
+func (s *Settings) setupIndexers(ctx context.Context, indexer client.FieldIndexer) error {
+	return indexer.IndexField(ctx, &v1.Deployment{}, indexInstrumentation, func(obj client.Object) []string {
+		return s.Options().instrumentationReferences(obj)
+	})
+}
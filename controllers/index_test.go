@@ -0,0 +1,241 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// indexedClient filters the listed Deployments by the instrumentation index like the cache,
+// the fake client ignores the field selectors.
+type indexedClient struct {
+	client.Client
+	opts Options
+}
+
+func (c indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.Client.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	deps, ok := list.(*v1.DeploymentList)
+	if !ok || listOpts.FieldSelector == nil || listOpts.FieldSelector.Empty() {
+		return nil
+	}
+	value, _ := listOpts.FieldSelector.RequiresExactMatch(indexInstrumentation)
+	var indexed []v1.Deployment
+	for i := range deps.Items {
+		for _, reference := range c.opts.instrumentationReferences(&deps.Items[i]) {
+			if reference == value {
+				indexed = append(indexed, deps.Items[i])
+			}
+		}
+	}
+	deps.Items = indexed
+	return nil
+}
+
+func TestInstrumentationReferences(t *testing.T) {
+	deployment := func(labels, annotations, templateLabels map[string]string) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop", Labels: labels, Annotations: annotations}}
+		dep.Spec.Template.Labels = templateLabels
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		return dep
+	}
+	referenced := []string{instrumentationName}
+
+	tests := []struct {
+		name     string
+		obj      client.Object
+		optIn    inject.OptIn
+		expected []string
+	}{
+		{
+			name: "not a Deployment",
+			obj:  &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		},
+		{
+			name: "plain",
+			obj:  deployment(map[string]string{"app": "backend"}, map[string]string{"owner": "payments"}, nil),
+		},
+		{
+			name:     "instrumented",
+			obj:      instrumentedDeployment(t, v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}, nil),
+			expected: referenced,
+		},
+		{
+			name:     "opted in by the pod template label",
+			obj:      deployment(nil, nil, map[string]string{inject.DefaultOptInLabel: "true"}),
+			expected: referenced,
+		},
+		{
+			name:     "opted out by the workload annotation",
+			obj:      deployment(nil, map[string]string{inject.DefaultOptInAnnotation: "false"}, nil),
+			expected: referenced,
+		},
+		{
+			name:  "opt-in label not configured",
+			obj:   deployment(map[string]string{inject.DefaultOptInLabel: "true"}, nil, nil),
+			optIn: inject.OptIn{Annotation: inject.DefaultOptInAnnotation},
+		},
+		{
+			name:     "canary",
+			obj:      deployment(nil, nil, map[string]string{inject.CanaryPercentLabel: "10"}),
+			expected: referenced,
+		},
+		{
+			name:     "blocked",
+			obj:      deployment(nil, map[string]string{annotationBlocked: "CrashLoopBackOff"}, nil),
+			expected: referenced,
+		},
+		{
+			name:     "pending changes",
+			obj:      deployment(nil, map[string]string{annotationPendingChanges: "env app/OTEL_TRACES_SAMPLER"}, nil),
+			expected: referenced,
+		},
+		{
+			name:     "debugging session",
+			obj:      deployment(nil, map[string]string{annotationTTL: "1h"}, nil),
+			expected: referenced,
+		},
+		{
+			name:     "expired debugging session",
+			obj:      deployment(nil, map[string]string{annotationSessionExpired: "true"}, nil),
+			expected: referenced,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			optIn := test.optIn
+			if optIn == (inject.OptIn{}) {
+				optIn = inject.DefaultOptIn()
+			}
+			if references := (Options{OptIn: optIn}).instrumentationReferences(test.obj); !reflect.DeepEqual(references, test.expected) {
+				t.Errorf("expected the references %v, got %v", test.expected, references)
+			}
+		})
+	}
+}
+
+func TestInstrumentationDeployments(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	deployment := func(namespace, name string, labels, templateLabels map[string]string) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+		dep.Spec.Template.Labels = templateLabels
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		return dep
+	}
+	instrumented := instrumentedDeployment(t, v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}, nil)
+	instrumented.Name = "instrumented"
+	all := []string{"instrumented", "opted-in", "plain", "selected"}
+
+	tests := []struct {
+		name             string
+		instrumentation  string
+		selector         *metav1.LabelSelector
+		namespaceEnabled bool
+		expected         []string
+	}{
+		{
+			name:            "other CR",
+			instrumentation: "other",
+			selector:        &metav1.LabelSelector{MatchLabels: map[string]string{"app": "selected"}},
+		},
+		{
+			name:            "no workload selector",
+			instrumentation: instrumentationName,
+			expected:        all,
+		},
+		{
+			name:            "workload selector",
+			instrumentation: instrumentationName,
+			selector:        &metav1.LabelSelector{MatchLabels: map[string]string{"app": "selected"}},
+			expected:        []string{"instrumented", "opted-in", "selected"},
+		},
+		{
+			name:             "workload selector, namespace opted in",
+			instrumentation:  instrumentationName,
+			selector:         &metav1.LabelSelector{MatchLabels: map[string]string{"app": "selected"}},
+			namespaceEnabled: true,
+			expected:         all,
+		},
+		{
+			name:            "invalid workload selector",
+			instrumentation: instrumentationName,
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: "Unknown", Values: []string{"selected"}},
+			}},
+			expected: all,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+			if test.namespaceEnabled {
+				ns.Labels = map[string]string{inject.DefaultOptInLabel: "true"}
+			}
+			opts := Options{OptIn: inject.DefaultOptIn()}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				ns,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+				instrumented.DeepCopy(),
+				deployment("shop", "opted-in", nil, map[string]string{inject.DefaultOptInLabel: "true"}),
+				deployment("shop", "plain", nil, nil),
+				deployment("shop", "selected", map[string]string{"app": "selected"}, nil),
+				deployment("other", "selected", map[string]string{"app": "selected"}, nil),
+			).Build()
+			r := &DeploymentControllerReconciler{Client: indexedClient{Client: c, opts: opts}, Settings: NewSettings(opts)}
+
+			instrumentation := &v1alpha1.OpenTelemetryInstrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: test.instrumentation, Namespace: "shop"},
+				Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{WorkloadSelector: test.selector},
+			}
+			var names []string
+			for _, request := range r.instrumentationDeployments(instrumentation) {
+				if request.Namespace != "shop" {
+					t.Errorf("expected only the Deployments of the namespace of the CR, got %v", request)
+				}
+				names = append(names, request.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected the Deployments %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
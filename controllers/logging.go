@@ -0,0 +1,67 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Decisions of the workload writer, they are logged and recorded on the spans.
+const (
+	decisionUpdated              = "updated"
+	decisionUnchanged            = "unchanged"
+	decisionDeferred             = "deferred"
+	decisionThrottled            = "throttled"
+	decisionAudited              = "audited"
+	decisionCanary               = "canary"
+	decisionInvalidConfiguration = "invalid-configuration"
+	decisionRolledBack           = "rolled-back"
+)
+
+// withInstrumentation adds the name of the instrumentation CR to the logger of the context.
+func withInstrumentation(ctx context.Context, instrumentation *v1alpha1.OpenTelemetryInstrumentation) context.Context {
+	if instrumentation == nil {
+		return ctx
+	}
+	return log.IntoContext(ctx, log.FromContext(ctx).WithValues("instrumentation", instrumentation.Name))
+}
+
+// workloadLogger returns the logger of the context with the keys of the workload.
+func workloadLogger(ctx context.Context, kind string, obj client.Object) logr.Logger {
+	return log.FromContext(ctx).WithValues("workloadKind", kind, "workloadNamespace", obj.GetNamespace(), "workloadName", obj.GetName())
+}
+
+// logDecision records the decision about the Deployment on the current span and in the log.
+// Unchanged workloads are logged at the debug level.
+func logDecision(ctx context.Context, obj client.Object, decision string, diff []string, keysAndValues ...interface{}) {
+	setDecision(ctx, decision)
+	logger := workloadLogger(ctx, "Deployment", obj).WithValues("decision", decision)
+	if len(diff) > 0 {
+		logger = logger.WithValues("diff", strings.Join(diff, ", "))
+	}
+	if decision == decisionUnchanged {
+		logger.V(1).Info("workload reconciled", keysAndValues...)
+		return
+	}
+	logger.Info("workload reconciled", keysAndValues...)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "otel_instrumentation"
+
+	// languageJava is the only language instrumented by the operator.
+	languageJava = "java"
+
+	stateInstrumented    = "instrumented"
+	stateCanary          = "canary"
+	stateBlocked         = "blocked"
+	stateNotInstrumented = "not_instrumented"
+
+	collectTimeout = 10 * time.Second
+)
+
+var (
+	injectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "injections_total",
+		Help:      "Number of workload updates injecting or updating the instrumentation.",
+	}, []string{"namespace", "kind"})
+	cleanupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cleanups_total",
+		Help:      "Number of workload updates removing the instrumentation.",
+	}, []string{"namespace", "kind"})
+	orphansTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "orphans_total",
+		Help:      "Number of instrumented workloads not enabled by any instrumentation CR found by the sweep.",
+	}, []string{"namespace", "kind"})
+	lastSweepTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_sweep_timestamp_seconds",
+		Help:      "Time of the last finished sweep for orphaned instrumentation.",
+	})
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of instrumentation failures by the reason of the reported warning event.",
+	}, []string{"reason"})
+
+	workloadsDesc = prometheus.NewDesc(metricsNamespace+"_workloads",
+		"Number of workloads by the instrumentation state: instrumented, canary, blocked or not_instrumented.",
+		[]string{"namespace", "kind", "language", "state"}, nil)
+	crInfoDesc = prometheus.NewDesc(metricsNamespace+"_cr_info",
+		"Information about the instrumentation CRs, the value is always 1.",
+		[]string{"namespace", "name", "mode", "rollout_policy", "paused", "valid"}, nil)
+)
+
+func init() {
+	metrics.Registry.MustRegister(injectionsTotal, cleanupsTotal, orphansTotal, lastSweepTimestamp, errorsTotal)
+}
+
+// countError records an instrumentation failure reported by a warning event.
+func countError(reason string) {
+	errorsTotal.WithLabelValues(reason).Inc()
+}
+
+// CoverageCollector reports the instrumentation coverage of the workloads and the instrumentation CRs.
+// The objects are read from the manager cache when the metrics are scraped.
+type CoverageCollector struct {
+	Reader   client.Reader
+	Settings *Settings
+}
+
+// NewCoverageCollector creates a collector reading the workloads and CRs by the reader.
+// The workloads owned by other operator instances are not counted.
+func NewCoverageCollector(reader client.Reader, settings *Settings) *CoverageCollector {
+	return &CoverageCollector{Reader: reader, Settings: settings}
+}
+
+// Describe implements prometheus.Collector.
+func (c *CoverageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- workloadsDesc
+	ch <- crInfoDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *CoverageCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	logger := log.FromContext(ctx).WithName("metrics")
+
+	deps := &v1.DeploymentList{}
+	if err := c.Reader.List(ctx, deps); err != nil {
+		logger.Error(err, "cannot list deployments")
+	}
+	type key struct{ namespace, state string }
+	counts := map[key]int{}
+	opts := c.Settings.Options()
+	for i := range deps.Items {
+		if !opts.ownsWorkload(&deps.Items[i]) {
+			continue
+		}
+		counts[key{deps.Items[i].Namespace, workloadState(&deps.Items[i])}]++
+	}
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(workloadsDesc, prometheus.GaugeValue, float64(count),
+			k.namespace, "Deployment", languageJava, k.state)
+	}
+
+	instrumentations := &v1alpha1.OpenTelemetryInstrumentationList{}
+	if err := c.Reader.List(ctx, instrumentations); err != nil {
+		logger.Error(err, "cannot list instrumentations")
+	}
+	for _, inst := range instrumentations.Items {
+		mode := v1alpha1.ModeEnforce
+		if inst.Spec.Mode.IsAudit() {
+			mode = v1alpha1.ModeAudit
+		}
+		policy := inst.Spec.RolloutPolicy
+		if policy == "" {
+			policy = v1alpha1.RolloutImmediate
+		}
+		valid := "unknown"
+		if condition := meta.FindStatusCondition(inst.Status.Conditions, v1alpha1.ConditionValid); condition != nil {
+			valid = strconv.FormatBool(condition.Status == metav1.ConditionTrue)
+		}
+		ch <- prometheus.MustNewConstMetric(crInfoDesc, prometheus.GaugeValue, 1,
+			inst.Namespace, inst.Name, string(mode), string(policy), strconv.FormatBool(inst.Spec.Paused), valid)
+	}
+}
+
+func workloadState(dep *v1.Deployment) string {
+	if _, blocked := dep.Annotations[annotationBlocked]; blocked {
+		return stateBlocked
+	}
+	if inject.IsInjected(&dep.Spec.Template.Spec) {
+		return stateInstrumented
+	}
+	if _, canary := inject.CanaryPercent(dep.Spec.Template.ObjectMeta); canary {
+		return stateCanary
+	}
+	return stateNotInstrumented
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestCoverageCollector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	deployment := func(namespace, name string, injected bool, annotations, templateLabels map[string]string) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations}}
+		dep.Spec.Template.Labels = templateLabels
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		if injected {
+			workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+			if err := inject.InjectPod(metav1.ObjectMeta{Name: namespace}, workload, &dep.Spec.Template.Spec,
+				v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dep
+	}
+	owned := map[string]string{InstanceLabel: DefaultInstanceID}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		deployment("shop", "cart", true, owned, nil),
+		deployment("shop", "checkout", true, owned, nil),
+		deployment("shop", "opted-out", false, nil, map[string]string{inject.DefaultOptInLabel: "false"}),
+		deployment("shop", "blocked", false, map[string]string{annotationBlocked: "CrashLoopBackOff"}, nil),
+		deployment("shop", "canary", false, nil, map[string]string{inject.CanaryPercentLabel: "10"}),
+		// instrumented by another operator instance, not counted
+		deployment("shop", "other-instance", true, map[string]string{InstanceLabel: "other"}, nil),
+		// the excluded namespaces are counted, their workloads are not instrumented
+		deployment("kube-system", "coredns", false, nil, nil),
+		&v1alpha1.OpenTelemetryInstrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "instrumentation", Namespace: "shop"},
+			Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{Mode: "audit", RolloutPolicy: v1alpha1.RolloutManual},
+		},
+		&v1alpha1.OpenTelemetryInstrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "instrumentation", Namespace: "payments"},
+			Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{Paused: true},
+			Status: v1alpha1.OpenTelemetryInstrumentationStatus{Conditions: []metav1.Condition{
+				{Type: v1alpha1.ConditionValid, Status: metav1.ConditionTrue, Reason: "Valid"},
+			}},
+		},
+	).Build()
+
+	collector := NewCoverageCollector(c, NewSettings(Options{OptIn: inject.DefaultOptIn(), ExcludedNamespaces: inject.DefaultExcludedNamespaces}))
+	expected := `
+# HELP otel_instrumentation_cr_info Information about the instrumentation CRs, the value is always 1.
+# TYPE otel_instrumentation_cr_info gauge
+otel_instrumentation_cr_info{mode="Audit",name="instrumentation",namespace="shop",paused="false",rollout_policy="Manual",valid="unknown"} 1
+otel_instrumentation_cr_info{mode="Enforce",name="instrumentation",namespace="payments",paused="true",rollout_policy="Immediate",valid="true"} 1
+# HELP otel_instrumentation_workloads Number of workloads by the instrumentation state: instrumented, canary, blocked or not_instrumented.
+# TYPE otel_instrumentation_workloads gauge
+otel_instrumentation_workloads{kind="Deployment",language="java",namespace="kube-system",state="not_instrumented"} 1
+otel_instrumentation_workloads{kind="Deployment",language="java",namespace="shop",state="blocked"} 1
+otel_instrumentation_workloads{kind="Deployment",language="java",namespace="shop",state="canary"} 1
+otel_instrumentation_workloads{kind="Deployment",language="java",namespace="shop",state="instrumented"} 2
+otel_instrumentation_workloads{kind="Deployment",language="java",namespace="shop",state="not_instrumented"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -18,39 +18,52 @@ package controllers
 
 import (
 	"context"
-	"fmt"
-	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	"sort"
+
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
-)
-
-const (
-	javaInstrumentationLabel = "opentelemetry-inst-java"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
 )
 
 // OpenTelemetryInstrumentationReconciler reconciles a OpenTelemetryInstrumentation object
 type OpenTelemetryInstrumentationReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Settings *Settings
+	// Reconciler configures the concurrency and rate limiting of the reconciler.
+	Reconciler ReconcilerOptions
 }
 
 //+kubebuilder:rbac:groups=opentelemetry.io,resources=opentelemetryinstrumentations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=opentelemetry.io,resources=opentelemetryinstrumentations/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=opentelemetry.io,resources=opentelemetryinstrumentations/finalizers,verbs=update
-func (r *OpenTelemetryInstrumentationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-	fmt.Println("OpenTelemetryInstrumentation reconcile: " + req.Namespace + "/" + req.Name)
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+func (r *OpenTelemetryInstrumentationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := startReconcileSpan(ctx, "OpenTelemetryInstrumentation", req)
+	defer func() { endSpan(span, err) }()
+	log.FromContext(ctx).V(1).Info("reconciling")
+	opts := r.Settings.Options()
 
 	instrumentation := &v1alpha1.OpenTelemetryInstrumentation{}
-	err := r.Client.Get(ctx, req.NamespacedName, instrumentation)
+	err = r.Client.Get(ctx, req.NamespacedName, instrumentation)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return ctrl.Result{}, nil
@@ -58,41 +71,143 @@ func (r *OpenTelemetryInstrumentationReconciler) Reconcile(ctx context.Context,
 		return ctrl.Result{}, err
 	}
 
-	ns := &corev1.Namespace{}
-	if err := r.Client.Get(ctx, types.NamespacedName{
-		Name: req.Namespace,
-	}, ns); err != nil {
+	originalStatus := instrumentation.Status.DeepCopy()
+	reason, validationErr := validate(instrumentation.Spec)
+	setValidCondition(instrumentation, reason, validationErr)
+	if validationErr != nil {
+		if previous := meta.FindStatusCondition(originalStatus.Conditions, v1alpha1.ConditionValid); previous == nil ||
+			previous.Status != metav1.ConditionFalse || previous.Message != validationErr.Error() {
+			countError(reasonInvalidConfiguration)
+			r.Recorder.Event(instrumentation, corev1.EventTypeWarning, reasonInvalidConfiguration, truncate(validationErr.Error()))
+		}
+		// keep the workloads as they are until the configuration is fixed
+		return ctrl.Result{}, r.updateStatus(ctx, instrumentation, originalStatus)
+	}
+
+	if isPaused(ctx, instrumentation) {
+		return ctrl.Result{}, r.updateStatus(ctx, instrumentation, originalStatus)
+	}
+
+	ns, err := getNamespace(ctx, r.Client, opts, req.Namespace)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if ns == nil {
+		// the namespace is not cached, it does not match the namespace cache selector
+		return ctrl.Result{}, r.updateStatus(ctx, instrumentation, originalStatus)
+	}
 
 	deps := &v1.DeploymentList{}
 	if err := r.Client.List(ctx, deps, client.InNamespace(req.Namespace)); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	for _, dep := range deps.Items {
-		if inject.IsInstrumentationEnabled(javaInstrumentationLabel, dep.ObjectMeta, ns.ObjectMeta) {
-			inject.InjectPod(dep.ObjectMeta, &dep.Spec.Template.Spec, instrumentation.Spec)
-			if err := r.Client.Update(ctx, &dep); err != nil {
-				return ctrl.Result{}, err
-			}
-		} else {
-			objectUpdated := inject.Clean(&dep.Spec.Template.Spec)
-			if objectUpdated {
-				fmt.Println("-----> Updating removed instance " + req.String())
-				if err := r.Client.Update(ctx, &dep); err != nil {
-					return ctrl.Result{}, err
-				}
+	// the workloads are changed by DeploymentControllerReconciler, the CR status is only summarized here
+	var matched []v1alpha1.WorkloadReference
+	if _, excluded := opts.ExcludedNamespace(ns.Name); !excluded {
+		for i := range deps.Items {
+			if opts.ownsWorkload(&deps.Items[i]) && inject.SelectorsMatch(instrumentation.Spec, ns.ObjectMeta, deps.Items[i].ObjectMeta) {
+				matched = append(matched, v1alpha1.WorkloadReference{Kind: "Deployment", Name: deps.Items[i].Name})
 			}
 		}
 	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+	instrumentation.Status.MatchedWorkloads = matched
+	pruneStatus(&instrumentation.Status, deps.Items)
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{}, r.updateStatus(ctx, instrumentation, originalStatus)
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// pruneStatus drops the audit changes, stale workloads and failures of deleted Deployments.
+func pruneStatus(status *v1alpha1.OpenTelemetryInstrumentationStatus, deps []v1.Deployment) {
+	var existing []v1alpha1.WorkloadReference
+	for _, dep := range deps {
+		existing = append(existing, v1alpha1.WorkloadReference{Kind: "Deployment", Name: dep.Name})
+	}
+
+	var changes []v1alpha1.AuditChange
+	for _, change := range status.AuditChanges {
+		if containsWorkload(existing, change.Workload) {
+			changes = append(changes, change)
+		}
+	}
+	status.AuditChanges = changes
+
+	var stale []v1alpha1.WorkloadReference
+	for _, workload := range status.StaleWorkloads {
+		if containsWorkload(existing, workload) {
+			stale = append(stale, workload)
+		}
+	}
+	status.StaleWorkloads = stale
+
+	var failures []v1alpha1.WorkloadFailure
+	for _, failure := range status.Failures {
+		if containsWorkload(existing, failure.Workload) {
+			failures = append(failures, failure)
+		}
+	}
+	status.Failures = failures
+}
+
+// setValidCondition records the result of the configuration validation in the CR status.
+func setValidCondition(instrumentation *v1alpha1.OpenTelemetryInstrumentation, reason string, validationErr error) {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            "Configuration is valid",
+		ObservedGeneration: instrumentation.Generation,
+	}
+	if validationErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Message = validationErr.Error()
+	}
+	meta.SetStatusCondition(&instrumentation.Status.Conditions, condition)
+}
+
+// updateStatus writes the CR status if it differs from the original status.
+func (r *OpenTelemetryInstrumentationReconciler) updateStatus(ctx context.Context, instrumentation *v1alpha1.OpenTelemetryInstrumentation, original *v1alpha1.OpenTelemetryInstrumentationStatus) error {
+	if equality.Semantic.DeepEqual(original, &instrumentation.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, instrumentation)
+}
+
+// SetupWithManager sets up the controller with the Manager. Status updates of the CR are ignored,
+// created and deleted Deployments, changed labels and reloaded settings refresh the matched workloads of the CR.
 func (r *OpenTelemetryInstrumentationReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1alpha1.OpenTelemetryInstrumentation{}).
-		Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.OpenTelemetryInstrumentation{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WithOptions(r.Reconciler.controllerOptions()).
+		Watches(&source.Kind{Type: &v1.Deployment{}}, handler.EnqueueRequestsFromMapFunc(namespaceInstrumentation),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&source.Channel{Source: r.Settings.subscribe()}, handler.EnqueueRequestsFromMapFunc(r.allInstrumentations))
+	if !r.Settings.Options().namespaceScoped() {
+		b = b.Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetName(), Name: instrumentationName}}}
+		}), builder.WithPredicates(predicate.LabelChangedPredicate{}))
+	}
+	return b.Complete(r)
+}
+
+// allInstrumentations maps a settings change to all instrumentation CRs.
+func (r *OpenTelemetryInstrumentationReconciler) allInstrumentations(client.Object) []reconcile.Request {
+	instrumentations := &v1alpha1.OpenTelemetryInstrumentationList{}
+	if err := r.Client.List(context.Background(), instrumentations); err != nil {
+		ctrl.Log.WithName("instrumentation-mapper").Error(err, "cannot list instrumentations")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(instrumentations.Items))
+	for i := range instrumentations.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&instrumentations.Items[i])})
+	}
+	return requests
+}
+
+// namespaceInstrumentation maps an object to the instrumentation CR of its namespace.
+func namespaceInstrumentation(obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: instrumentationName}}}
 }
@@ -0,0 +1,155 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	v1 "k8s.io/api/apps/v1"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// Options holds the operator settings shared by the reconcilers.
+type Options struct {
+	// OptIn holds the label and annotation keys which enable the instrumentation.
+	OptIn inject.OptIn
+	// ExcludedNamespaces are glob patterns (see path.Match) of namespaces which are never instrumented.
+	// Existing instrumentation is removed from workloads in these namespaces.
+	ExcludedNamespaces []string
+	// DryRun enables the audit mode for all instrumentation CRs.
+	DryRun bool
+	// MaxConcurrentRollouts caps the Deployments with an in-progress rollout in the cluster
+	// the operator starts a new rollout at, zero means unlimited.
+	MaxConcurrentRollouts int
+	// MaxConcurrentRolloutsPerNamespace is like MaxConcurrentRollouts, but per namespace.
+	MaxConcurrentRolloutsPerNamespace int
+	// WatchNamespaces restricts the operator to the namespaces, empty means the whole cluster.
+	// Namespace objects are not read in the namespace-scoped mode.
+	WatchNamespaces []string
+	// InstanceID distinguishes operator instances running side by side. An instance reconciles only the CRs
+	// labeled by its ID (CRs without the label if the ID is empty) and the workloads it owns.
+	// The workloads of the instance without an ID are owned by DefaultInstanceID.
+	InstanceID string
+	// Defaults are used for the settings missing in the instrumentation CRs.
+	Defaults configv1alpha1.InstrumentationDefaults
+}
+
+// instance returns the owner recorded on the workloads instrumented by the operator instance.
+func (o Options) instance() string {
+	if o.InstanceID == "" {
+		return DefaultInstanceID
+	}
+	return o.InstanceID
+}
+
+// ownsWorkload returns true if the Deployment is owned by the operator instance. The instrumentation injected
+// at build time is never touched. A Deployment without an owner is claimed by the instance without an ID,
+// it covers the workloads instrumented before the owner was recorded, the other instances claim it only
+// while it carries no instrumentation.
+func (o Options) ownsWorkload(dep *v1.Deployment) bool {
+	if owner, ok := dep.Annotations[InstanceLabel]; ok {
+		return owner == o.instance()
+	}
+	if _, buildTime := dep.Annotations[inject.BuildTimeAnnotation]; buildTime {
+		return false
+	}
+	return o.InstanceID == "" || !inject.HasMarkers(&dep.Spec.Template.Spec)
+}
+
+// namespaceScoped returns true if the operator watches only some namespaces.
+func (o Options) namespaceScoped() bool {
+	return len(o.WatchNamespaces) > 0
+}
+
+// auditMode returns true if the workload updates should be only validated and recorded.
+// The instrumentation CR can be nil.
+func (o Options) auditMode(instrumentation *v1alpha1.OpenTelemetryInstrumentation) bool {
+	return o.DryRun || (instrumentation != nil && instrumentation.Spec.Mode.IsAudit())
+}
+
+// policy returns the policy deciding which workloads are instrumented.
+func (o Options) policy() inject.Policy {
+	return inject.Policy{OptIn: o.OptIn, ExcludedNamespaces: o.ExcludedNamespaces}
+}
+
+// ExcludedNamespace returns the first exclusion pattern which matches the namespace.
+func (o Options) ExcludedNamespace(namespace string) (string, bool) {
+	return o.policy().ExcludedNamespace(namespace)
+}
+
+// ReconcilerOptions configure the concurrency and the rate limiting of a reconciler.
+type ReconcilerOptions struct {
+	// MaxConcurrentReconciles is the number of parallel reconciliations, zero means the concurrency
+	// of the group kind in the manager options or one.
+	MaxConcurrentReconciles int
+	RateLimiter             RateLimiterOptions
+}
+
+// RateLimiterOptions configure the workqueue rate limiter. Failed reconciliations are retried with an exponential
+// delay between BaseDelay and MaxDelay, all queued reconciliations are limited by QPS and Burst.
+type RateLimiterOptions struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	QPS       float64
+	Burst     int
+}
+
+// DefaultRateLimiterOptions are the settings of the default controller-runtime rate limiter.
+func DefaultRateLimiterOptions() RateLimiterOptions {
+	return RateLimiterOptions{
+		BaseDelay: 5 * time.Millisecond,
+		MaxDelay:  1000 * time.Second,
+		QPS:       10,
+		Burst:     100,
+	}
+}
+
+// WithConfig returns the rate limiter options overridden by the settings of the configuration file.
+// The flags set on the command line take precedence over the file, explicit holds their names, e.g. rate-limiter-qps.
+func (o RateLimiterOptions) WithConfig(config configv1alpha1.RateLimiterConfig, explicit map[string]bool) RateLimiterOptions {
+	if config.BaseDelay != nil && !explicit["rate-limiter-base-delay"] {
+		o.BaseDelay = config.BaseDelay.Duration
+	}
+	if config.MaxDelay != nil && !explicit["rate-limiter-max-delay"] {
+		o.MaxDelay = config.MaxDelay.Duration
+	}
+	if config.QPS != nil && !explicit["rate-limiter-qps"] {
+		o.QPS = *config.QPS
+	}
+	if config.Burst != nil && !explicit["rate-limiter-burst"] {
+		o.Burst = *config.Burst
+	}
+	return o
+}
+
+// controllerOptions returns the controller options of the reconciler.
+func (o ReconcilerOptions) controllerOptions() controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(o.RateLimiter.BaseDelay, o.RateLimiter.MaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(o.RateLimiter.QPS), o.RateLimiter.Burst)},
+		),
+	}
+}
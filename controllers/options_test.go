@@ -0,0 +1,153 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestOwnsWorkload(t *testing.T) {
+	deployment := func(owner string, injected bool) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop"}}
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		if owner != "" {
+			dep.Annotations = map[string]string{InstanceLabel: owner}
+		}
+		if injected {
+			workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+			if err := inject.InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, &dep.Spec.Template.Spec,
+				v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dep
+	}
+	buildTime := func(dep *v1.Deployment) *v1.Deployment {
+		metav1.SetMetaDataAnnotation(&dep.ObjectMeta, inject.BuildTimeAnnotation, "true")
+		return dep
+	}
+
+	tests := []struct {
+		name       string
+		instanceID string
+		dep        *v1.Deployment
+		owned      bool
+	}{
+		{name: "no owner, not injected", dep: deployment("", false), owned: true},
+		{name: "no owner, not injected, named instance", instanceID: "canary", dep: deployment("", false), owned: true},
+		{name: "no owner, injected", dep: deployment("", true), owned: true},
+		{name: "no owner, injected, named instance", instanceID: "canary", dep: deployment("", true)},
+		{name: "build time", dep: buildTime(deployment("", true))},
+		{name: "build time, named instance", instanceID: "canary", dep: buildTime(deployment("", true))},
+		{name: "default owner", dep: deployment(DefaultInstanceID, true), owned: true},
+		{name: "default owner, named instance", instanceID: "canary", dep: deployment(DefaultInstanceID, true)},
+		{name: "named owner", instanceID: "canary", dep: deployment("canary", true), owned: true},
+		{name: "named owner, default instance", dep: deployment("canary", true)},
+		{name: "other named owner", instanceID: "canary", dep: deployment("beta", false)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if owned := (Options{InstanceID: test.instanceID}).ownsWorkload(test.dep); owned != test.owned {
+				t.Errorf("expected %v, got %v", test.owned, owned)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWithConfig(t *testing.T) {
+	qps, burst := 50.0, 500
+	config := configv1alpha1.RateLimiterConfig{
+		BaseDelay: &metav1.Duration{Duration: time.Second},
+		MaxDelay:  &metav1.Duration{Duration: time.Minute},
+		QPS:       &qps,
+		Burst:     &burst,
+	}
+	flags := DefaultRateLimiterOptions()
+
+	tests := []struct {
+		name     string
+		config   configv1alpha1.RateLimiterConfig
+		explicit map[string]bool
+		expected RateLimiterOptions
+	}{
+		{name: "not configured", expected: flags},
+		{name: "configured", config: config, expected: RateLimiterOptions{BaseDelay: time.Second, MaxDelay: time.Minute, QPS: qps, Burst: burst}},
+		{
+			name:     "explicit flags",
+			config:   config,
+			explicit: map[string]bool{"rate-limiter-base-delay": true, "rate-limiter-max-delay": true, "rate-limiter-qps": true, "rate-limiter-burst": true},
+			expected: flags,
+		},
+		{
+			name:     "explicit qps",
+			config:   config,
+			explicit: map[string]bool{"rate-limiter-qps": true},
+			expected: RateLimiterOptions{BaseDelay: time.Second, MaxDelay: time.Minute, QPS: flags.QPS, Burst: burst},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if opts := flags.WithConfig(test.config, test.explicit); opts != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, opts)
+			}
+		})
+	}
+}
+
+func TestControllerOptions(t *testing.T) {
+	opts := ReconcilerOptions{
+		MaxConcurrentReconciles: 4,
+		RateLimiter:             RateLimiterOptions{BaseDelay: time.Second, MaxDelay: 3 * time.Second, QPS: 10, Burst: 100},
+	}.controllerOptions()
+	if opts.MaxConcurrentReconciles != 4 {
+		t.Errorf("expected 4 concurrent reconciles, got %d", opts.MaxConcurrentReconciles)
+	}
+
+	// the retries of an item are delayed exponentially up to the max delay
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if delay := opts.RateLimiter.When("failing"); delay != expected {
+			t.Errorf("expected the retry %d to be delayed by %v, got %v", i, expected, delay)
+		}
+	}
+	if retries := opts.RateLimiter.NumRequeues("failing"); retries != 4 {
+		t.Errorf("expected 4 requeues, got %d", retries)
+	}
+	opts.RateLimiter.Forget("failing")
+	if delay := opts.RateLimiter.When("failing"); delay != time.Second {
+		t.Errorf("expected the base delay after forgetting the item, got %v", delay)
+	}
+
+	// the bucket limits all items, the burst of one is used up
+	opts = ReconcilerOptions{
+		RateLimiter: RateLimiterOptions{BaseDelay: time.Millisecond, MaxDelay: time.Second, QPS: 1, Burst: 1},
+	}.controllerOptions()
+	if delay := opts.RateLimiter.When("first"); delay != time.Millisecond {
+		t.Errorf("expected the base delay of the first item, got %v", delay)
+	}
+	if delay := opts.RateLimiter.When("second"); delay < 500*time.Millisecond {
+		t.Errorf("expected the second item to be delayed by the bucket, got %v", delay)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestPausedInstrumentation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	optIn := map[string]string{inject.DefaultOptInLabel: "true"}
+	backend := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop"}}
+	backend.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "backend:1"}}
+	// instrumented, but opted out afterwards
+	orphan := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "shop",
+		Labels: map[string]string{inject.DefaultOptInLabel: "false"}}}
+	orphan.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "orphan:1"}}
+	if err := inject.InjectPod(metav1.ObjectMeta{Name: "shop"}, inject.Workload{Kind: "Deployment", ObjectMeta: orphan.ObjectMeta},
+		&orphan.Spec.Template.Spec, v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+		t.Fatal(err)
+	}
+	canary := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: "shop", UID: "canary-uid"}}
+	canary.Spec.Template.Labels = map[string]string{inject.CanaryPercentLabel: "100"}
+	canary.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "canary:1"}}
+	replicaSet := &v1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "canary-1", Namespace: "shop", UID: "canary-1-uid",
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(canary, v1.SchemeGroupVersion.WithKind("Deployment"))}}}
+	instrumentation := &v1alpha1.OpenTelemetryInstrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: instrumentationName, Namespace: "shop"},
+		Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1", Paused: true},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: optIn}},
+		backend, orphan, canary, replicaSet, instrumentation,
+	).Build()
+
+	settings := NewSettings(Options{OptIn: inject.DefaultOptIn()})
+	gate := NewRolloutGate()
+	reconciler := &DeploymentControllerReconciler{
+		Client:    c,
+		Scheme:    scheme,
+		Recorder:  record.NewFakeRecorder(100),
+		APIReader: c,
+		Settings:  settings,
+		Gate:      gate,
+	}
+	sweeper := &Sweeper{Client: c, Recorder: record.NewFakeRecorder(100), Settings: settings, Gate: gate}
+	injector := &PodInjector{Client: c, APIReader: c, Settings: settings}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := injector.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "canary-1-a", Namespace: "shop", Labels: canary.Spec.Template.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(replicaSet, v1.SchemeGroupVersion.WithKind("ReplicaSet"))}},
+		Spec: canary.Spec.Template.Spec,
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podRequest := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Namespace: "shop",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+
+	ctx := context.Background()
+	// run reconciles the Deployments, sweeps the orphans and admits the canary pod
+	run := func() admission.Response {
+		t.Helper()
+		for _, name := range []string{"backend", "orphan"} {
+			if _, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "shop", Name: name}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sweeper.sweep(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return injector.Handle(ctx, podRequest)
+	}
+	get := func(name string) *v1.Deployment {
+		t.Helper()
+		dep := &v1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: name}, dep); err != nil {
+			t.Fatal(err)
+		}
+		return dep
+	}
+
+	response := run()
+	if dep := get("backend"); dep.ResourceVersion != backend.ResourceVersion || inject.HasMarkers(&dep.Spec.Template.Spec) {
+		t.Errorf("expected the paused instrumentation to leave the backend unchanged, got %+v", dep)
+	}
+	if dep := get("orphan"); dep.ResourceVersion != orphan.ResourceVersion || !inject.IsInjected(&dep.Spec.Template.Spec) {
+		t.Errorf("expected the paused instrumentation to leave the orphan unchanged, got %+v", dep)
+	}
+	if !response.Allowed || len(response.Patches) > 0 {
+		t.Errorf("expected the canary pod to be admitted unchanged, got %+v", response)
+	}
+
+	paused := &v1alpha1.OpenTelemetryInstrumentation{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(instrumentation), paused); err != nil {
+		t.Fatal(err)
+	}
+	paused.Spec.Paused = false
+	if err := c.Update(ctx, paused); err != nil {
+		t.Fatal(err)
+	}
+
+	response = run()
+	if dep := get("backend"); !inject.IsInjected(&dep.Spec.Template.Spec) {
+		t.Error("expected the backend to be instrumented after unpausing")
+	}
+	if dep := get("orphan"); inject.HasMarkers(&dep.Spec.Template.Spec) {
+		t.Error("expected the orphan to be cleaned after unpausing")
+	}
+	if !response.Allowed || len(response.Patches) == 0 {
+		t.Errorf("expected the canary pod to be instrumented after unpausing, got %+v", response)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mpod.opentelemetry.io,admissionReviewVersions=v1
+
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get
+
+// PodInjector instruments the canary pods of Deployments with the canary-percent label
+// on the pod template. The pod templates of these Deployments are not instrumented by the reconcilers.
+type PodInjector struct {
+	Client client.Client
+	// APIReader reads the ReplicaSet owning the pod directly from the API server.
+	APIReader client.Reader
+	Settings  *Settings
+
+	decoder *admission.Decoder
+}
+
+func (p *PodInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := p.decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	opts := p.Settings.Options()
+	percent, canary := inject.CanaryPercent(pod.ObjectMeta)
+	if !canary || inject.IsInjected(&pod.Spec) {
+		return admission.Allowed("not a canary pod")
+	}
+
+	ns, err := getNamespace(ctx, p.Client, opts, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if ns == nil {
+		return admission.Allowed("namespace is not managed")
+	}
+	instrumentation, err := getInstrumentation(ctx, p.Client, req.Namespace)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if instrumentation == nil || isPaused(ctx, instrumentation) || opts.auditMode(instrumentation) {
+		return admission.Allowed("instrumentation is not active")
+	}
+	dep, err := p.owningDeployment(ctx, req.Namespace, pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if dep == nil || !opts.ownsWorkload(dep) || !isInstrumentationEnabled(opts, ns, dep, instrumentation) {
+		return admission.Allowed("instrumentation is not enabled")
+	}
+
+	// the name is assigned here instead of by the API server to make the choice deterministic
+	if pod.Name == "" && pod.GenerateName != "" {
+		pod.Name = pod.GenerateName + utilrand.String(5)
+	}
+	injected := inject.InCanary(pod.Name, percent)
+	if injected {
+		workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+		if err := inject.InjectPod(ns.ObjectMeta, workload, &pod.Spec, sessionSpec(inject.WithDefaults(instrumentation.Spec, opts.Defaults), dep)); err != nil {
+			workloadLogger(ctx, "Deployment", dep).Error(err, "cannot inject instrumentation into pod", "pod", pod.Name)
+			return admission.Allowed("instrumentation cannot be injected")
+		}
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[inject.InjectedLabel] = strconv.FormatBool(injected)
+
+	marshaled, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// owningDeployment returns the Deployment which owns the pod through a ReplicaSet, nil for other pods.
+func (p *PodInjector) owningDeployment(ctx context.Context, namespace string, pod *corev1.Pod) (*v1.Deployment, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "ReplicaSet" {
+		return nil, nil
+	}
+	rs := &v1.ReplicaSet{}
+	if err := p.APIReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: owner.Name}, rs); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	owner = metav1.GetControllerOf(rs)
+	if owner == nil || owner.Kind != "Deployment" {
+		return nil, nil
+	}
+	dep := &v1.Deployment{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: owner.Name}, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dep, nil
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (p *PodInjector) InjectDecoder(d *admission.Decoder) error {
+	p.decoder = d
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// annotationRolloutPriority orders the rollouts in a namespace. Deployments with a higher priority
+	// are rolled out first, the next wave starts when all rollouts of the previous wave completed.
+	annotationRolloutPriority = "instrumentation.opentelemetry.io/rollout-priority"
+
+	// rolloutRequeueInterval is the delay before a throttled rollout is retried.
+	rolloutRequeueInterval = 15 * time.Second
+
+	// reasonNewReplicaSetAvailable and reasonProgressDeadlineExceeded are the reasons of the Progressing
+	// condition of a completed and failed rollout set by the deployment controller.
+	reasonNewReplicaSetAvailable   = "NewReplicaSetAvailable"
+	reasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+)
+
+// RolloutGate limits the rollouts started by the operator. It caps the number of Deployments
+// with an in-progress rollout and orders the rollouts in a namespace into waves by priority.
+// The gate is shared by the parallel reconciliations, a rollout it started counts as in progress
+// until the cache shows the updated generation of the Deployment.
+type RolloutGate struct {
+	mu sync.Mutex
+	// rollouts maps the Deployments rolled out by the gate to the generation of their update,
+	// zero while the update is in flight
+	rollouts map[types.NamespacedName]int64
+}
+
+// NewRolloutGate creates the gate shared by the writers of the workloads.
+func NewRolloutGate() *RolloutGate {
+	return &RolloutGate{rollouts: map[types.NamespacedName]int64{}}
+}
+
+// allow returns true if the Deployment can be rolled out now. An allowed rollout is reserved until
+// the update of the Deployment is reported by started or release.
+func (g *RolloutGate) allow(ctx context.Context, c client.Client, opts Options, dep *v1.Deployment) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	allowed, err := g.check(ctx, c, opts, dep)
+	if err != nil || !allowed {
+		return false, err
+	}
+	g.rollouts[client.ObjectKeyFromObject(dep)] = 0
+	return true, nil
+}
+
+// started records the generation of the Deployment updated by the operator.
+func (g *RolloutGate) started(dep *v1.Deployment) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollouts[client.ObjectKeyFromObject(dep)] = dep.Generation
+}
+
+// release drops the reservation of a Deployment which could not be updated.
+func (g *RolloutGate) release(dep *v1.Deployment) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.rollouts, client.ObjectKeyFromObject(dep))
+}
+
+func (g *RolloutGate) check(ctx context.Context, c client.Client, opts Options, dep *v1.Deployment) (bool, error) {
+	deps := &v1.DeploymentList{}
+	if err := c.List(ctx, deps, client.InNamespace(dep.Namespace)); err != nil {
+		return false, err
+	}
+	g.expire(dep.Namespace, deps.Items)
+	priority := rolloutPriority(dep)
+	inProgress := 0
+	for i := range deps.Items {
+		other := &deps.Items[i]
+		if other.Name == dep.Name {
+			continue
+		}
+		if rolloutPriority(other) > priority {
+			if g.inProgress(other) {
+				// the previous wave has not completed yet
+				return false, nil
+			}
+			// the Deployments are reconciled in parallel, the previous wave may not have started yet
+			if pending, err := rolloutPending(ctx, c, opts, other); pending || err != nil {
+				return false, err
+			}
+			continue
+		}
+		if g.inProgress(other) {
+			inProgress++
+		}
+	}
+	if opts.MaxConcurrentRolloutsPerNamespace > 0 && inProgress >= opts.MaxConcurrentRolloutsPerNamespace {
+		return false, nil
+	}
+
+	if opts.MaxConcurrentRollouts > 0 {
+		all := &v1.DeploymentList{}
+		if err := c.List(ctx, all); err != nil {
+			return false, err
+		}
+		g.expire(metav1.NamespaceAll, all.Items)
+		inProgress = 0
+		for i := range all.Items {
+			other := &all.Items[i]
+			if (other.Namespace != dep.Namespace || other.Name != dep.Name) && g.inProgress(other) {
+				inProgress++
+			}
+		}
+		if inProgress >= opts.MaxConcurrentRollouts {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// inProgress returns true if the rollout of the Deployment is in progress or it was started by the gate
+// and the cache does not show it yet.
+func (g *RolloutGate) inProgress(dep *v1.Deployment) bool {
+	if _, started := g.rollouts[client.ObjectKeyFromObject(dep)]; started {
+		return true
+	}
+	return rolloutInProgress(dep)
+}
+
+// expire drops the rollouts the cached Deployments of the namespace (all namespaces if empty) already show,
+// and the rollouts of deleted Deployments.
+func (g *RolloutGate) expire(namespace string, deps []v1.Deployment) {
+	cached := make(map[types.NamespacedName]int64, len(deps))
+	for i := range deps {
+		cached[client.ObjectKeyFromObject(&deps[i])] = deps[i].Generation
+	}
+	for key, generation := range g.rollouts {
+		if generation == 0 || (namespace != metav1.NamespaceAll && key.Namespace != namespace) {
+			continue
+		}
+		if current, ok := cached[key]; !ok || current >= generation {
+			delete(g.rollouts, key)
+		}
+	}
+}
+
+// rolloutPending returns true if the instrumentation change of the Deployment has not been rolled out yet.
+// Changes deferred by the rollout policy are not pending.
+func rolloutPending(ctx context.Context, c client.Client, opts Options, dep *v1.Deployment) (bool, error) {
+	if _, deferred := dep.Annotations[annotationPendingChanges]; deferred || !opts.ownsWorkload(dep) {
+		return false, nil
+	}
+	ns, err := getNamespace(ctx, c, opts, dep.Namespace)
+	if err != nil || ns == nil {
+		return false, err
+	}
+	instrumentation, err := getInstrumentation(ctx, c, dep.Namespace)
+	if err != nil {
+		return false, err
+	}
+	return wouldChange(opts, ns, dep, instrumentation), nil
+}
+
+// rolloutInProgress returns true if the Deployment has not finished its rollout. The completion is reported
+// by the deployment controller in the Progressing condition of the observed generation: NewReplicaSetAvailable
+// for a completed rollout and ProgressDeadlineExceeded for a failed one, which is not in progress anymore either.
+// A settled Deployment with an unavailable pod does not block the other rollouts. Without the condition
+// (no progress deadline) the rollout is in progress until all replicas are updated.
+func rolloutInProgress(dep *v1.Deployment) bool {
+	if dep.Generation > dep.Status.ObservedGeneration {
+		return true
+	}
+	for _, c := range dep.Status.Conditions {
+		if c.Type == v1.DeploymentProgressing {
+			return c.Reason != reasonNewReplicaSetAvailable && c.Reason != reasonProgressDeadlineExceeded
+		}
+	}
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.UpdatedReplicas < replicas
+}
+
+// rolloutPriority returns the rollout priority of the Deployment, 0 if it is not set or invalid.
+func rolloutPriority(dep *v1.Deployment) int {
+	priority, err := strconv.Atoi(dep.Annotations[annotationRolloutPriority])
+	if err != nil {
+		return 0
+	}
+	return priority
+}
+
+// sortByRolloutPriority orders the Deployments by descending rollout priority.
+func sortByRolloutPriority(deps []v1.Deployment) {
+	sort.SliceStable(deps, func(i, j int) bool {
+		return rolloutPriority(&deps[i]) > rolloutPriority(&deps[j])
+	})
+}
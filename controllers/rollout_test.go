@@ -0,0 +1,143 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRolloutGate(t *testing.T) {
+	deployment := func(name string) *v1.Deployment {
+		return &v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Generation: 1},
+			Status:     v1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		}
+	}
+	first, second := deployment("first"), deployment("second")
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(first, second).Build()
+	opts := Options{MaxConcurrentRolloutsPerNamespace: 1}
+	gate := NewRolloutGate()
+	ctx := context.Background()
+
+	if allowed, err := gate.allow(ctx, c, opts, first); err != nil || !allowed {
+		t.Fatalf("expected the first rollout to be allowed, got %v, %v", allowed, err)
+	}
+	// the reservation counts before the update of the first Deployment
+	if allowed, _ := gate.allow(ctx, c, opts, second); allowed {
+		t.Fatal("expected the second rollout to wait for the reserved one")
+	}
+
+	first.Generation = 2
+	gate.started(first)
+	// the cache still shows the previous generation of the first Deployment
+	if allowed, _ := gate.allow(ctx, c, opts, second); allowed {
+		t.Fatal("expected the second rollout to wait for the started one")
+	}
+
+	// the cache shows the completed rollout of the first Deployment
+	cached := &v1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(first), cached); err != nil {
+		t.Fatal(err)
+	}
+	cached.Generation, cached.Status.ObservedGeneration = 2, 2
+	if err := c.Update(ctx, cached); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, err := gate.allow(ctx, c, opts, second); err != nil || !allowed {
+		t.Fatalf("expected the second rollout to be allowed, got %v, %v", allowed, err)
+	}
+
+	gate.release(second)
+	if _, reserved := gate.rollouts[client.ObjectKeyFromObject(second)]; reserved {
+		t.Error("expected the released rollout to be dropped")
+	}
+	if _, started := gate.rollouts[client.ObjectKeyFromObject(first)]; started {
+		t.Error("expected the rollout shown by the cache to expire")
+	}
+}
+
+func TestRolloutInProgress(t *testing.T) {
+	progressing := func(reason string) []v1.DeploymentCondition {
+		status := corev1.ConditionTrue
+		if reason == reasonProgressDeadlineExceeded {
+			status = corev1.ConditionFalse
+		}
+		return []v1.DeploymentCondition{{Type: v1.DeploymentProgressing, Status: status, Reason: reason}}
+	}
+	tests := []struct {
+		name       string
+		generation int64
+		status     v1.DeploymentStatus
+		inProgress bool
+	}{
+		{
+			name:       "generation not observed",
+			generation: 2,
+			status:     v1.DeploymentStatus{ObservedGeneration: 1, Conditions: progressing(reasonNewReplicaSetAvailable)},
+			inProgress: true,
+		},
+		{
+			name:       "new replica set updated",
+			generation: 2,
+			status:     v1.DeploymentStatus{ObservedGeneration: 2, Conditions: progressing("ReplicaSetUpdated")},
+			inProgress: true,
+		},
+		{
+			name:       "completed",
+			generation: 2,
+			status:     v1.DeploymentStatus{ObservedGeneration: 2, Conditions: progressing(reasonNewReplicaSetAvailable)},
+		},
+		{
+			name:       "completed with a crashing pod",
+			generation: 2,
+			status: v1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1,
+				UnavailableReplicas: 1, Conditions: progressing(reasonNewReplicaSetAvailable)},
+		},
+		{
+			name:       "progress deadline exceeded",
+			generation: 2,
+			status:     v1.DeploymentStatus{ObservedGeneration: 2, Conditions: progressing(reasonProgressDeadlineExceeded)},
+		},
+		{
+			name:       "no condition, replicas not updated",
+			generation: 2,
+			status:     v1.DeploymentStatus{ObservedGeneration: 2},
+			inProgress: true,
+		},
+		{
+			name:       "no condition, replicas updated",
+			generation: 2,
+			status:     v1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Generation: test.generation}, Status: test.status}
+			if inProgress := rolloutInProgress(dep); inProgress != test.inProgress {
+				t.Errorf("expected %v, got %v", test.inProgress, inProgress)
+			}
+		})
+	}
+}
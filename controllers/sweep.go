@@ -0,0 +1,121 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// Sweeper removes orphaned instrumentation from the workloads. The operator reacts to events only, a workload
+// stays instrumented if its CR was deleted or its label removed while the operator was down.
+// The sweep runs at the start and then periodically on the leader.
+type Sweeper struct {
+	Client   client.Client
+	Recorder record.EventRecorder
+	Settings *Settings
+	// Gate limits the rollouts of the cleanups together with the reconciler.
+	Gate *RolloutGate
+	// Interval between the sweeps, zero sweeps only at the start.
+	Interval time.Duration
+}
+
+// Start sweeps the workloads until the context is done. It implements manager.Runnable.
+func (s *Sweeper) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("sweeper")
+	ctx = log.IntoContext(ctx, logger)
+	if s.Interval == 0 {
+		if err := s.sweep(ctx); err != nil {
+			logger.Error(err, "sweep failed")
+		}
+		return nil
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.sweep(ctx); err != nil {
+			logger.Error(err, "sweep failed")
+		}
+	}, s.Interval)
+	return nil
+}
+
+// sweep cleans the Deployments carrying instrumentation markers which are not enabled by an instrumentation CR,
+// including the Deployments instrumented before the owner was recorded (see Options.ownsWorkload).
+// Namespaces with a paused or invalid CR are skipped like by the reconciler.
+func (s *Sweeper) sweep(ctx context.Context) error {
+	opts := s.Settings.Options()
+	deps := &v1.DeploymentList{}
+	if err := s.Client.List(ctx, deps); err != nil {
+		return err
+	}
+
+	orphans, cleaned := 0, 0
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		if !inject.HasMarkers(&dep.Spec.Template.Spec) || !opts.ownsWorkload(dep) {
+			continue
+		}
+		instrumentation, err := getInstrumentation(ctx, s.Client, dep.Namespace)
+		if err != nil {
+			return err
+		}
+		if instrumentation != nil {
+			if _, err := validate(instrumentation.Spec); err != nil || isPaused(ctx, instrumentation) {
+				continue
+			}
+		}
+		ns, err := getNamespace(ctx, s.Client, opts, dep.Namespace)
+		if err != nil {
+			return err
+		}
+		if ns == nil || (instrumentation != nil && isInstrumentationEnabled(opts, ns, dep, instrumentation)) {
+			continue
+		}
+
+		orphans++
+		orphansTotal.WithLabelValues(dep.Namespace, "Deployment").Inc()
+		ctx := withInstrumentation(ctx, instrumentation)
+		writer := newWorkloadWriter(s.Client, s.Recorder, s.Gate, opts, instrumentation)
+		writer.event(dep, corev1.EventTypeNormal, reasonOrphaned, "Instrumentation is not enabled by an instrumentation CR, removing it")
+		if err := writer.clean(ctx, dep); err != nil {
+			workloadLogger(ctx, "Deployment", dep).Error(err, "cannot remove orphaned instrumentation")
+			continue
+		}
+		if err := writer.updateStatus(ctx, instrumentation); err != nil {
+			workloadLogger(ctx, "Deployment", dep).Error(err, "cannot update instrumentation status")
+		}
+		if !writer.throttled && !writer.audit {
+			cleaned++
+		}
+	}
+	lastSweepTimestamp.SetToCurrentTime()
+	log.FromContext(ctx).Info("sweep finished", "workloads", len(deps.Items), "orphans", orphans, "cleaned", cleaned)
+	return nil
+}
+
+// NeedLeaderElection returns true, only the leader changes the workloads.
+func (s *Sweeper) NeedLeaderElection() bool {
+	return true
+}
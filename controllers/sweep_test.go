@@ -0,0 +1,97 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestSweep(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	deployment := func(name string, annotations map[string]string) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Annotations: annotations}}
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+		if err := inject.InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, &dep.Spec.Template.Spec,
+			v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+			t.Fatal(err)
+		}
+		return dep
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+		// instrumented before the owner was recorded
+		deployment("legacy", nil),
+		deployment("owned", map[string]string{InstanceLabel: DefaultInstanceID}),
+		deployment("canary", map[string]string{InstanceLabel: "canary"}),
+		deployment("build-time", map[string]string{inject.BuildTimeAnnotation: "true"}),
+	).Build()
+
+	s := &Sweeper{
+		Client:   c,
+		Recorder: record.NewFakeRecorder(100),
+		Settings: NewSettings(Options{OptIn: inject.DefaultOptIn()}),
+		Gate:     NewRolloutGate(),
+	}
+	ctx := context.Background()
+	if err := s.sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cleaned bool
+	}{
+		{name: "legacy", cleaned: true},
+		{name: "owned", cleaned: true},
+		{name: "canary"},
+		{name: "build-time"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dep := &v1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: test.name}, dep); err != nil {
+				t.Fatal(err)
+			}
+			if cleaned := !inject.HasMarkers(&dep.Spec.Template.Spec); cleaned != test.cleaned {
+				t.Errorf("expected cleaned %v, got %v", test.cleaned, cleaned)
+			}
+			if _, owned := dep.Annotations[InstanceLabel]; test.cleaned && owned {
+				t.Errorf("expected the owner of the cleaned Deployment to be removed, got %v", dep.Annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// annotationExpiresAt ends the debugging session of a Deployment at the given RFC 3339 time.
+	annotationExpiresAt = "instrumentation.opentelemetry.io/expires-at"
+	// annotationTTL starts a debugging session of the given duration, it is replaced by annotationExpiresAt.
+	annotationTTL = "instrumentation.opentelemetry.io/ttl"
+	// annotationDebugSampling samples all traces of the Deployment during the debugging session.
+	annotationDebugSampling = "instrumentation.opentelemetry.io/debug-sampling"
+	// annotationSessionOptIn records that the opt-in annotation came with the debugging session,
+	// the opt-in annotation is removed when the session expires.
+	annotationSessionOptIn = "instrumentation.opentelemetry.io/session-opt-in"
+	// annotationSessionExpired marks an instrumented Deployment whose debugging session expired, the instrumentation
+	// is injected from scratch by the next update of the pod template, which removes the annotation.
+	annotationSessionExpired = "instrumentation.opentelemetry.io/session-expired"
+
+	debugSampler = "always_on"
+)
+
+// timeBox handles the debugging session of the Deployment. A TTL is converted to the expiration time
+// when it is seen first. The opt-in annotation of a Deployment which is not instrumented yet when the session
+// starts came with the session, it is recorded by annotationSessionOptIn. When the session expires the session
+// annotations and the opt-in annotation added by the session are removed, the Deployment falls back to the
+// configuration of the namespace, or keeps its own opt-in with the original sampler. The revert of an instrumented
+// Deployment is kept in annotationSessionExpired until its pod template is updated.
+// In the audit mode the annotations are only changed in memory.
+func (w *workloadWriter) timeBox(ctx context.Context, dep *v1.Deployment) error {
+	logger := workloadLogger(ctx, "Deployment", dep)
+	now := time.Now()
+	original := dep.DeepCopy()
+
+	expiresAt, err := time.Parse(time.RFC3339, dep.Annotations[annotationExpiresAt])
+	if _, ok := dep.Annotations[annotationExpiresAt]; ok && err != nil {
+		logger.Error(err, "invalid expiration time of the debugging session", "annotation", annotationExpiresAt)
+		return nil
+	}
+	if ttl, ok := dep.Annotations[annotationTTL]; ok && expiresAt.IsZero() {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			logger.Error(err, "invalid TTL of the debugging session", "annotation", annotationTTL)
+			return nil
+		}
+		expiresAt = now.Add(duration)
+		dep.Annotations[annotationExpiresAt] = expiresAt.UTC().Format(time.RFC3339)
+		delete(dep.Annotations, annotationTTL)
+	}
+	if expiresAt.IsZero() {
+		return nil
+	}
+
+	if now.Before(expiresAt) {
+		w.requeue(expiresAt.Sub(now))
+		if _, optIn := dep.Annotations[w.optInAnnotation]; optIn && w.optInAnnotation != "" && !inject.HasMarkers(&dep.Spec.Template.Spec) {
+			dep.Annotations[annotationSessionOptIn] = "true"
+		}
+	} else {
+		logger.Info("debugging session expired", "expiresAt", expiresAt)
+		remove := []string{annotationExpiresAt, annotationTTL, annotationDebugSampling, annotationSessionOptIn}
+		if _, sessionOptIn := dep.Annotations[annotationSessionOptIn]; sessionOptIn {
+			remove = append(remove, w.optInAnnotation)
+		}
+		for _, k := range remove {
+			delete(dep.Annotations, k)
+		}
+		if inject.HasMarkers(&dep.Spec.Template.Spec) {
+			dep.Annotations[annotationSessionExpired] = "true"
+		}
+	}
+	if w.audit || equality.Semantic.DeepEqual(original.Annotations, dep.Annotations) {
+		return nil
+	}
+	return w.client.Patch(ctx, dep, client.MergeFrom(original))
+}
+
+// sessionSpec returns the instrumentation configuration of the Deployment, the sampler is overridden
+// during a debugging session with debug sampling.
+func sessionSpec(spec v1alpha1.OpenTelemetryInstrumentationSpec, dep *v1.Deployment) v1alpha1.OpenTelemetryInstrumentationSpec {
+	_, session := dep.Annotations[annotationExpiresAt]
+	if session && dep.Annotations[annotationDebugSampling] == "true" {
+		spec.TracesSampler = debugSampler
+		spec.TracesSamplerArg = ""
+	}
+	return spec
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestTimeBox(t *testing.T) {
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	optIn := inject.DefaultOptInAnnotation
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		instrumented bool
+		expected     map[string]string
+		requeue      bool
+	}{
+		{
+			name:        "no session",
+			annotations: map[string]string{optIn: "true"},
+			expected:    map[string]string{optIn: "true"},
+		},
+		{
+			name:        "invalid expiration time",
+			annotations: map[string]string{annotationExpiresAt: "tomorrow"},
+			expected:    map[string]string{annotationExpiresAt: "tomorrow"},
+		},
+		{
+			name:        "session with its own opt-in",
+			annotations: map[string]string{optIn: "true", annotationExpiresAt: future},
+			expected:    map[string]string{optIn: "true", annotationExpiresAt: future, annotationSessionOptIn: "true"},
+			requeue:     true,
+		},
+		{
+			name:         "session of an instrumented Deployment",
+			annotations:  map[string]string{optIn: "true", annotationExpiresAt: future},
+			instrumented: true,
+			expected:     map[string]string{optIn: "true", annotationExpiresAt: future},
+			requeue:      true,
+		},
+		{
+			name:         "expired session removes its opt-in",
+			annotations:  map[string]string{optIn: "true", annotationExpiresAt: past, annotationDebugSampling: "true", annotationSessionOptIn: "true"},
+			instrumented: true,
+			expected:     map[string]string{annotationSessionExpired: "true"},
+		},
+		{
+			name:         "expired session keeps the previous opt-in",
+			annotations:  map[string]string{optIn: "true", annotationExpiresAt: past, annotationDebugSampling: "true"},
+			instrumented: true,
+			expected:     map[string]string{optIn: "true", annotationSessionExpired: "true"},
+		},
+		{
+			name:        "expired session of a Deployment which is not instrumented",
+			annotations: map[string]string{annotationExpiresAt: past},
+			expected:    map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop", Annotations: test.annotations}}
+			dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+			if test.instrumented {
+				workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+				if err := inject.InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, &dep.Spec.Template.Spec,
+					v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(dep.DeepCopy()).Build()
+			w := newWorkloadWriter(c, record.NewFakeRecorder(10), NewRolloutGate(), Options{OptIn: inject.DefaultOptIn()}, nil)
+			ctx := context.Background()
+			if err := w.timeBox(ctx, dep); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := &v1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(dep), stored); err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.Annotations) != len(test.expected) {
+				t.Fatalf("expected the annotations %v, got %v", test.expected, stored.Annotations)
+			}
+			for k, v := range test.expected {
+				if stored.Annotations[k] != v {
+					t.Errorf("expected the annotations %v, got %v", test.expected, stored.Annotations)
+				}
+			}
+			if requeue := w.requeueAfter > 0; requeue != test.requeue {
+				t.Errorf("expected requeue %v, got %v", test.requeue, w.requeueAfter)
+			}
+		})
+	}
+}
+
+func TestTimeBoxConvertsTTL(t *testing.T) {
+	dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop", Annotations: map[string]string{annotationTTL: "2h"}}}
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(dep.DeepCopy()).Build()
+	w := newWorkloadWriter(c, record.NewFakeRecorder(10), NewRolloutGate(), Options{}, nil)
+	if err := w.timeBox(context.Background(), dep); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dep.Annotations[annotationTTL]; ok {
+		t.Error("expected the TTL to be replaced")
+	}
+	expiresAt, err := time.Parse(time.RFC3339, dep.Annotations[annotationExpiresAt])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiresAt); d < time.Hour || d > 2*time.Hour {
+		t.Errorf("unexpected expiration time %v", expiresAt)
+	}
+	if w.requeueAfter <= time.Hour {
+		t.Errorf("expected the requeue at the expiration, got %v", w.requeueAfter)
+	}
+}
+
+func TestSessionSpec(t *testing.T) {
+	spec := v1alpha1.OpenTelemetryInstrumentationSpec{TracesSampler: "parentbased_traceidratio", TracesSamplerArg: "0.1"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		sampler     string
+		samplerArg  string
+	}{
+		{name: "no session", sampler: spec.TracesSampler, samplerArg: spec.TracesSamplerArg},
+		{
+			name:        "debug sampling without a session",
+			annotations: map[string]string{annotationDebugSampling: "true"},
+			sampler:     spec.TracesSampler,
+			samplerArg:  spec.TracesSamplerArg,
+		},
+		{
+			name:        "session without debug sampling",
+			annotations: map[string]string{annotationExpiresAt: "2021-01-01T00:00:00Z"},
+			sampler:     spec.TracesSampler,
+			samplerArg:  spec.TracesSamplerArg,
+		},
+		{
+			name:        "session with debug sampling",
+			annotations: map[string]string{annotationExpiresAt: "2021-01-01T00:00:00Z", annotationDebugSampling: "true"},
+			sampler:     debugSampler,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+			actual := sessionSpec(spec, dep)
+			if actual.TracesSampler != test.sampler || actual.TracesSamplerArg != test.samplerArg {
+				t.Errorf("expected %q %q, got %q %q", test.sampler, test.samplerArg, actual.TracesSampler, actual.TracesSamplerArg)
+			}
+		})
+	}
+}
+
+func TestExpiredSessionSurvivesThrottling(t *testing.T) {
+	instrumentation := &v1alpha1.OpenTelemetryInstrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: instrumentationName, Namespace: "shop"},
+		Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"},
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	settled := v1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	dep := &v1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "backend",
+			Namespace:   "shop",
+			Generation:  1,
+			Annotations: map[string]string{annotationExpiresAt: "2021-01-01T00:00:00Z", annotationDebugSampling: "true"},
+		},
+		Status: settled,
+	}
+	dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+	workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+	if err := inject.InjectPod(ns.ObjectMeta, workload, &dep.Spec.Template.Spec, sessionSpec(instrumentation.Spec, dep)); err != nil {
+		t.Fatal(err)
+	}
+	other := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "shop", Generation: 1}, Status: settled}
+	c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(dep, other).Build()
+	opts := Options{OptIn: inject.DefaultOptIn(), MaxConcurrentRolloutsPerNamespace: 1}
+	gate := NewRolloutGate()
+	ctx := context.Background()
+
+	reconcile := func() *v1.Deployment {
+		dep := &v1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "backend"}, dep); err != nil {
+			t.Fatal(err)
+		}
+		w := newWorkloadWriter(c, record.NewFakeRecorder(10), gate, opts, instrumentation)
+		if err := w.timeBox(ctx, dep); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.inject(ctx, ns, dep, instrumentation); err != nil {
+			t.Fatal(err)
+		}
+		stored := &v1.Deployment{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(dep), stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+	sampler := func(dep *v1.Deployment) string {
+		for _, env := range dep.Spec.Template.Spec.Containers[0].Env {
+			if env.Name == "OTEL_TRACES_SAMPLER" {
+				return env.Value
+			}
+		}
+		return ""
+	}
+
+	// the rollout of the other Deployment throttles the revert
+	if allowed, err := gate.allow(ctx, c, opts, other); err != nil || !allowed {
+		t.Fatalf("expected the other rollout to be allowed, got %v, %v", allowed, err)
+	}
+	throttled := reconcile()
+	if _, expired := throttled.Annotations[annotationSessionExpired]; !expired {
+		t.Fatalf("expected the pending revert to be kept, got %v", throttled.Annotations)
+	}
+	if sampler(throttled) != debugSampler {
+		t.Fatalf("expected the debug sampler until the revert, got %q", sampler(throttled))
+	}
+
+	gate.release(other)
+	reverted := reconcile()
+	if _, expired := reverted.Annotations[annotationSessionExpired]; expired {
+		t.Errorf("expected the pending revert to be removed, got %v", reverted.Annotations)
+	}
+	if sampler(reverted) != "" {
+		t.Errorf("expected the sampler of the CR, got %q", sampler(reverted))
+	}
+	if !inject.IsInjected(&reverted.Spec.Template.Spec) {
+		t.Error("expected the instrumentation to be injected again")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	tracerName  = "github.com/pavolloffay/opentelemetry-instrumentation-operator/controllers"
+	serviceName = "opentelemetry-instrumentation-operator"
+)
+
+// tracer traces the reconciliations. It does nothing until SetupTracing installs the tracer provider.
+var tracer = otel.Tracer(tracerName)
+
+// SetupTracing exports the spans of the operator by OTLP/HTTP to the endpoint (host:port).
+// The returned function flushes the spans and stops the exporter.
+func SetupTracing(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// startReconcileSpan starts the root span of a reconciliation.
+func startReconcileSpan(ctx context.Context, controller string, req ctrl.Request) (context.Context, trace.Span) {
+	return tracer.Start(ctx, controller+" reconcile", trace.WithAttributes(
+		attribute.String("controller", controller),
+		attribute.String("k8s.namespace.name", req.Namespace),
+		attribute.String("k8s.object.name", req.Name),
+	))
+}
+
+// endSpan records the error of the traced operation and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startWriterSpan starts the span of an inject, clean or rollback decision for the Deployment.
+func startWriterSpan(ctx context.Context, action string, dep client.Object) (context.Context, trace.Span) {
+	return tracer.Start(ctx, action, trace.WithAttributes(
+		attribute.String("k8s.namespace.name", dep.GetNamespace()),
+		attribute.String("k8s.deployment.name", dep.GetName()),
+	))
+}
+
+// setDecision records the outcome of the inject, clean or rollback decision on the current span.
+func setDecision(ctx context.Context, decision string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("instrumentation.decision", decision))
+}
+
+// NewTracingClient wraps the client to trace the API calls.
+func NewTracingClient(c client.Client) client.Client {
+	return &tracingClient{Client: c, reader: NewTracingReader(c)}
+}
+
+// NewTracingReader wraps the reader to trace the API calls.
+func NewTracingReader(r client.Reader) client.Reader {
+	return &tracingReader{Reader: r}
+}
+
+type tracingReader struct {
+	client.Reader
+}
+
+func (r *tracingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) (err error) {
+	ctx, span := startAPISpan(ctx, "get", obj)
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.String("k8s.namespace.name", key.Namespace), attribute.String("k8s.object.name", key.Name))
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func (r *tracingReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	ctx, span := startAPISpan(ctx, "list", list)
+	defer func() { endSpan(span, err) }()
+	return r.Reader.List(ctx, list, opts...)
+}
+
+type tracingClient struct {
+	client.Client
+	reader client.Reader
+}
+
+func (c *tracingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return c.reader.Get(ctx, key, obj)
+}
+
+func (c *tracingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.reader.List(ctx, list, opts...)
+}
+
+func (c *tracingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) (err error) {
+	ctx, span := startObjectSpan(ctx, "create", obj)
+	defer func() { endSpan(span, err) }()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) (err error) {
+	ctx, span := startObjectSpan(ctx, "delete", obj)
+	defer func() { endSpan(span, err) }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := startObjectSpan(ctx, "update", obj)
+	defer func() { endSpan(span, err) }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := startObjectSpan(ctx, "patch", obj)
+	defer func() { endSpan(span, err) }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *tracingClient) Status() client.StatusWriter {
+	return &tracingStatusWriter{StatusWriter: c.Client.Status()}
+}
+
+type tracingStatusWriter struct {
+	client.StatusWriter
+}
+
+func (w *tracingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := startObjectSpan(ctx, "update status", obj)
+	defer func() { endSpan(span, err) }()
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *tracingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := startObjectSpan(ctx, "patch status", obj)
+	defer func() { endSpan(span, err) }()
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func startAPISpan(ctx context.Context, verb string, obj interface{}) (context.Context, trace.Span) {
+	kind := fmt.Sprintf("%T", obj)
+	return tracer.Start(ctx, "k8s "+verb, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("k8s.verb", verb),
+		attribute.String("k8s.type", kind),
+	))
+}
+
+func startObjectSpan(ctx context.Context, verb string, obj client.Object) (context.Context, trace.Span) {
+	ctx, span := startAPISpan(ctx, verb, obj)
+	span.SetAttributes(attribute.String("k8s.namespace.name", obj.GetNamespace()), attribute.String("k8s.object.name", obj.GetName()))
+	return ctx, span
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestReconcileTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "shop",
+			Labels: map[string]string{inject.DefaultOptInLabel: "true"},
+		}},
+		&v1alpha1.OpenTelemetryInstrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: instrumentationName, Namespace: "shop"},
+			Spec:       v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"},
+		},
+		&v1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop"},
+			Spec: v1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "backend"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "backend"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "backend:1"}}},
+				},
+			},
+		},
+	).Build()
+
+	r := &DeploymentControllerReconciler{
+		Client:    NewTracingClient(c),
+		Scheme:    scheme,
+		Recorder:  record.NewFakeRecorder(100),
+		APIReader: NewTracingReader(c),
+		Settings:  NewSettings(Options{OptIn: inject.DefaultOptIn()}),
+		Gate:      NewRolloutGate(),
+	}
+	key := types.NamespacedName{Namespace: "shop", Name: "backend"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	if len(spans["Deployment reconcile"]) != 1 {
+		t.Fatalf("expected one reconcile span, got %v", spans)
+	}
+	root := spans["Deployment reconcile"][0]
+	if root.Parent().IsValid() {
+		t.Error("the reconcile span is not a root span")
+	}
+
+	for _, name := range []string{"k8s get", "k8s update", "inject"} {
+		if len(spans[name]) == 0 {
+			t.Errorf("no %q span", name)
+		}
+		for _, span := range spans[name] {
+			if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+				t.Errorf("span %q is not in the trace of the reconciliation", name)
+			}
+		}
+	}
+	for _, span := range spans["k8s get"] {
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the reconcile span", span.Name())
+		}
+	}
+	for _, span := range spans["k8s update"] {
+		if span.Parent().SpanID() != spans["inject"][0].SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the inject span", span.Name())
+		}
+	}
+
+	decision := ""
+	for _, kv := range spans["inject"][0].Attributes() {
+		if kv.Key == "instrumentation.decision" {
+			decision = kv.Value.AsString()
+		}
+	}
+	if decision != decisionUpdated {
+		t.Errorf("expected the decision %q, got %q", decisionUpdated, decision)
+	}
+}
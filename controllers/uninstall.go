@@ -0,0 +1,210 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// uninstallPageSize is the number of Deployments listed at once by Uninstall.
+const uninstallPageSize = 500
+
+// operatorAnnotations hold the state of the operator on a Deployment, they are removed by Uninstall.
+// The opt-in and debugging session annotations are set by users and are kept.
+var operatorAnnotations = []string{annotationPendingChanges, annotationApplyPending, annotationTemplateHash, annotationBlocked,
+	annotationSessionOptIn, annotationSessionExpired, annotationRolloutGeneration, InstanceLabel}
+
+// Uninstall removes the instrumentation and the state of the operator from all Deployments of the cluster, or of
+// the watched namespaces, owned by the operator instance, and the operator finalizers from the instrumentation CRs.
+// The CRs of the instance are paused first and the running operator gets pauseDelay to observe it, so that it does
+// not inject the reverted Deployments again. The CRs stay paused. Failures to revert a Deployment or CR do not stop
+// the uninstall, they are returned together at the end.
+func Uninstall(ctx context.Context, c client.Client, opts Options, pauseDelay time.Duration) error {
+	namespaces := opts.WatchNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	selector, err := instanceSelector(opts.InstanceID)
+	if err != nil {
+		return err
+	}
+	var instrumentations []v1alpha1.OpenTelemetryInstrumentation
+	for _, namespace := range namespaces {
+		list := &v1alpha1.OpenTelemetryInstrumentationList{}
+		if err := c.List(ctx, list, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return err
+		}
+		instrumentations = append(instrumentations, list.Items...)
+	}
+
+	var errs []error
+	paused := 0
+	for i := range instrumentations {
+		changed, err := pause(ctx, c, opts, &instrumentations[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instrumentation %s/%s: %w", instrumentations[i].Namespace, instrumentations[i].Name, err))
+		}
+		if changed {
+			paused++
+		}
+	}
+	if len(errs) > 0 {
+		// the operator would inject the Deployments of the CRs which are not paused again
+		return utilerrors.NewAggregate(errs)
+	}
+	if paused > 0 && !opts.DryRun {
+		log.FromContext(ctx).Info("waiting for the operator to observe the paused instrumentation CRs", "paused", paused, "delay", pauseDelay)
+		select {
+		case <-time.After(pauseDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for _, namespace := range namespaces {
+		listOpts := &client.ListOptions{Namespace: namespace, Limit: uninstallPageSize}
+		for {
+			deps := &v1.DeploymentList{}
+			if err := c.List(ctx, deps, listOpts); err != nil {
+				return utilerrors.NewAggregate(append(errs, err))
+			}
+			for i := range deps.Items {
+				if err := uninstallDeployment(ctx, c, opts, client.ObjectKeyFromObject(&deps.Items[i])); err != nil {
+					errs = append(errs, fmt.Errorf("deployment %s/%s: %w", deps.Items[i].Namespace, deps.Items[i].Name, err))
+				}
+			}
+			if deps.Continue == "" {
+				break
+			}
+			listOpts.Continue = deps.Continue
+		}
+	}
+
+	for i := range instrumentations {
+		if err := removeFinalizers(ctx, c, opts, &instrumentations[i]); err != nil {
+			errs = append(errs, fmt.Errorf("instrumentation %s/%s: %w", instrumentations[i].Namespace, instrumentations[i].Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// pause stops the operator from changing the Deployments of the CR. It returns true if the CR was not paused yet.
+func pause(ctx context.Context, c client.Client, opts Options, instrumentation *v1alpha1.OpenTelemetryInstrumentation) (bool, error) {
+	if instrumentation.Spec.Paused {
+		return false, nil
+	}
+	patched := instrumentation.DeepCopy()
+	patched.Spec.Paused = true
+	var patchOpts []client.PatchOption
+	if opts.DryRun {
+		patchOpts = append(patchOpts, client.DryRunAll)
+	}
+	if err := c.Patch(ctx, patched, client.MergeFrom(instrumentation), patchOpts...); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	log.FromContext(ctx).Info("instrumentation paused", "instrumentation", instrumentation.Name, "namespace", instrumentation.Namespace)
+	if !opts.DryRun {
+		*instrumentation = *patched
+	}
+	return true, nil
+}
+
+// errNotOwned is returned for an instrumented Deployment without an owner which is skipped by a named instance.
+var errNotOwned = fmt.Errorf("instrumented without an owner, run the uninstall without --instance-id to revert it")
+
+// uninstallDeployment reverts the Deployment, conflicting updates are retried with the latest version.
+// The Deployments owned by other instances and the instrumentation injected at build time are skipped,
+// an instrumented Deployment without an owner skipped by a named instance is returned as errNotOwned.
+func uninstallDeployment(ctx context.Context, c client.Client, opts Options, key client.ObjectKey) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dep := &v1.Deployment{}
+		if err := c.Get(ctx, key, dep); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !opts.ownsWorkload(dep) {
+			_, annotated := dep.Annotations[InstanceLabel]
+			_, buildTime := dep.Annotations[inject.BuildTimeAnnotation]
+			if !annotated && !buildTime && inject.HasMarkers(&dep.Spec.Template.Spec) {
+				return errNotOwned
+			}
+			workloadLogger(ctx, "Deployment", dep).V(1).Info("deployment skipped, it is not owned by the instance")
+			return nil
+		}
+		cleaned := inject.Clean(&dep.Spec.Template.Spec)
+		annotated := false
+		for _, annotation := range operatorAnnotations {
+			if _, ok := dep.Annotations[annotation]; ok {
+				delete(dep.Annotations, annotation)
+				annotated = true
+			}
+		}
+		if !cleaned && !annotated {
+			return nil
+		}
+		var updateOpts []client.UpdateOption
+		if opts.DryRun {
+			updateOpts = append(updateOpts, client.DryRunAll)
+		}
+		if err := c.Update(ctx, dep, updateOpts...); err != nil {
+			return err
+		}
+		workloadLogger(ctx, "Deployment", dep).Info("deployment reverted", "instrumentationRemoved", cleaned, "dryRun", opts.DryRun)
+		return nil
+	})
+}
+
+// removeFinalizers removes the finalizers of the operator domain from the CR, so that the CR
+// and its CRD can be deleted once the operator is gone.
+func removeFinalizers(ctx context.Context, c client.Client, opts Options, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+	var finalizers []string
+	for _, finalizer := range instrumentation.Finalizers {
+		if !strings.HasSuffix(strings.SplitN(finalizer, "/", 2)[0], v1alpha1.GroupVersion.Group) {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	if len(finalizers) == len(instrumentation.Finalizers) {
+		return nil
+	}
+	patched := instrumentation.DeepCopy()
+	patched.Finalizers = finalizers
+	var patchOpts []client.PatchOption
+	if opts.DryRun {
+		patchOpts = append(patchOpts, client.DryRunAll)
+	}
+	if err := c.Patch(ctx, patched, client.MergeFrom(instrumentation), patchOpts...); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.FromContext(ctx).Info("finalizers removed", "instrumentation", instrumentation.Name, "namespace", instrumentation.Namespace)
+	return nil
+}
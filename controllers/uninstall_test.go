@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+func TestUninstall(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	instrumentation := func(namespace string, labels map[string]string) *v1alpha1.OpenTelemetryInstrumentation {
+		return &v1alpha1.OpenTelemetryInstrumentation{ObjectMeta: metav1.ObjectMeta{
+			Name:       instrumentationName,
+			Namespace:  namespace,
+			Labels:     labels,
+			Finalizers: []string{"instrumentation.opentelemetry.io/cleanup", "example.com/keep"},
+		}}
+	}
+	deployment := func(namespace, owner string) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name:        "backend",
+			Namespace:   namespace,
+			Annotations: map[string]string{annotationTemplateHash: "0123"},
+		}}
+		if owner != "" {
+			dep.Annotations[InstanceLabel] = owner
+		}
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+		if err := inject.InjectPod(metav1.ObjectMeta{Name: namespace}, workload, &dep.Spec.Template.Spec,
+			v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+			t.Fatal(err)
+		}
+		return dep
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		instrumentation("shop", nil),
+		deployment("shop", DefaultInstanceID),
+		instrumentation("canary", map[string]string{InstanceLabel: "canary"}),
+		deployment("canary", "canary"),
+		instrumentation("other", nil),
+		deployment("other", DefaultInstanceID),
+		// instrumented before the owner was recorded
+		instrumentation("legacy", nil),
+		deployment("legacy", ""),
+	).Build()
+
+	ctx := context.Background()
+	// a named instance does not revert the Deployments without an owner, but it reports them
+	err := Uninstall(ctx, c, Options{InstanceID: "canary", WatchNamespaces: []string{"legacy"}}, 0)
+	if err == nil || !strings.Contains(err.Error(), "deployment legacy/backend") {
+		t.Fatalf("expected the skipped Deployment to be reported, got %v", err)
+	}
+	if err := Uninstall(ctx, c, Options{WatchNamespaces: []string{"shop", "canary", "legacy"}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		namespace string
+		reverted  bool
+	}{
+		{namespace: "shop", reverted: true},
+		{namespace: "canary"},
+		{namespace: "other"},
+		{namespace: "legacy", reverted: true},
+	}
+	for _, test := range tests {
+		t.Run(test.namespace, func(t *testing.T) {
+			cr := &v1alpha1.OpenTelemetryInstrumentation{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: test.namespace, Name: instrumentationName}, cr); err != nil {
+				t.Fatal(err)
+			}
+			dep := &v1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: test.namespace, Name: "backend"}, dep); err != nil {
+				t.Fatal(err)
+			}
+			if cr.Spec.Paused != test.reverted {
+				t.Errorf("expected paused %v, got %v", test.reverted, cr.Spec.Paused)
+			}
+			if finalizers := len(cr.Finalizers); (finalizers == 1) != test.reverted {
+				t.Errorf("expected the operator finalizer to be removed %v, got %v", test.reverted, cr.Finalizers)
+			}
+			if inject.HasMarkers(&dep.Spec.Template.Spec) == test.reverted {
+				t.Errorf("expected the instrumentation to be removed %v", test.reverted)
+			}
+			if _, owned := dep.Annotations[InstanceLabel]; owned == test.reverted {
+				t.Errorf("expected the operator annotations to be removed %v, got %v", test.reverted, dep.Annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const instrumentationName = "opentelemetry-instrumentation"
+
+// getInstrumentation returns the instrumentation CR of the namespace or nil if it does not exist.
+func getInstrumentation(ctx context.Context, c client.Client, namespace string) (*v1alpha1.OpenTelemetryInstrumentation, error) {
+	instrumentation := &v1alpha1.OpenTelemetryInstrumentation{}
+	err := c.Get(ctx, types.NamespacedName{
+		Namespace: namespace,
+		Name:      instrumentationName,
+	}, instrumentation)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return instrumentation, nil
+}
+
+// getNamespace returns the namespace of the workloads, nil if it is not cached. In the namespace-scoped mode
+// Namespace objects are not read, the namespace is returned without labels and annotations and only the labels
+// and annotations of the workloads opt in. The cluster-scoped operator requires the permission to watch Namespaces.
+func getNamespace(ctx context.Context, c client.Client, opts Options, name string) (*corev1.Namespace, error) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if opts.namespaceScoped() {
+		return ns, nil
+	}
+	err := c.Get(ctx, types.NamespacedName{Name: name}, ns)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// isPaused returns true if the instrumentation CR stops all changes of the workloads.
+// The instrumentation CR can be nil.
+func isPaused(ctx context.Context, instrumentation *v1alpha1.OpenTelemetryInstrumentation) bool {
+	if instrumentation == nil || !instrumentation.Spec.Paused {
+		return false
+	}
+	log.FromContext(ctx).Info("instrumentation is paused, workloads are not changed", "instrumentation", instrumentation.Name)
+	return true
+}
+
+// isInstrumentationEnabled decides by the policy of the options whether the Deployment is instrumented.
+// The instrumentation CR can be nil.
+func isInstrumentationEnabled(opts Options, ns *corev1.Namespace, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) bool {
+	var spec *v1alpha1.OpenTelemetryInstrumentationSpec
+	if instrumentation != nil {
+		spec = &instrumentation.Spec
+	}
+	return opts.policy().Enabled(ns.ObjectMeta, dep.ObjectMeta, dep.Spec.Template.ObjectMeta, spec)
+}
+
+// wouldChange returns true if reconciling the Deployment would change its pod template.
+func wouldChange(opts Options, ns *corev1.Namespace, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) bool {
+	if instrumentation != nil && (instrumentation.Spec.Paused || opts.auditMode(instrumentation)) {
+		return false
+	}
+	desired := dep.DeepCopy()
+	_, canary := inject.CanaryPercent(desired.Spec.Template.ObjectMeta)
+	if !isInstrumentationEnabled(opts, ns, desired, instrumentation) || canary {
+		inject.Clean(&desired.Spec.Template.Spec)
+	} else if instrumentation != nil {
+		if _, expired := desired.Annotations[annotationSessionExpired]; expired {
+			inject.Clean(&desired.Spec.Template.Spec)
+		}
+		workload := inject.Workload{Kind: "Deployment", ObjectMeta: desired.ObjectMeta}
+		if err := inject.InjectPod(ns.ObjectMeta, workload, &desired.Spec.Template.Spec, sessionSpec(inject.WithDefaults(instrumentation.Spec, opts.Defaults), desired)); err != nil {
+			return false
+		}
+	}
+	return !equality.Semantic.DeepEqual(dep.Spec.Template, desired.Spec.Template)
+}
+
+// validate checks the instrumentation configuration, it returns the reason for the Valid condition.
+func validate(spec v1alpha1.OpenTelemetryInstrumentationSpec) (string, error) {
+	if err := inject.Validate(spec); err != nil {
+		return v1alpha1.ReasonInvalidResourceAttributes, err
+	}
+	if _, err := metav1.LabelSelectorAsSelector(spec.WorkloadSelector); err != nil {
+		return v1alpha1.ReasonInvalidSelector, fmt.Errorf("workloadSelector: %w", err)
+	}
+	if _, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+		return v1alpha1.ReasonInvalidSelector, fmt.Errorf("namespaceSelector: %w", err)
+	}
+	return v1alpha1.ReasonValid, nil
+}
@@ -0,0 +1,481 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	actionInject = "Inject"
+	actionClean  = "Clean"
+
+	// annotationPendingChanges lists the configuration changes deferred by the rollout policy.
+	annotationPendingChanges = "instrumentation.opentelemetry.io/pending-changes"
+	// annotationApplyPending approves the pending changes with the Manual rollout policy.
+	annotationApplyPending = "instrumentation.opentelemetry.io/apply-pending"
+	// annotationTemplateHash is the hash of the pod template the operator has last seen,
+	// it detects pod template changes with the OnNextRollout rollout policy.
+	annotationTemplateHash = "instrumentation.opentelemetry.io/template-hash"
+
+	// maxEventMessageLength keeps the event message within the limits of the API server.
+	maxEventMessageLength = 1024
+)
+
+// workloadWriter applies the instrumentation changes to workloads. In the audit mode the updates
+// are only validated by a server-side dry-run and recorded as events and audit changes.
+// Configuration changes of instrumented workloads are deferred according to the rollout policy.
+type workloadWriter struct {
+	client   client.Client
+	recorder record.EventRecorder
+	audit    bool
+	policy   v1alpha1.RolloutPolicy
+	gate     *RolloutGate
+	opts     Options
+	// instrumentation receives the events of the workloads, it can be nil
+	instrumentation *v1alpha1.OpenTelemetryInstrumentation
+	// optInAnnotation is removed from a Deployment when its debugging session expires
+	optInAnnotation string
+	// defaults are used for the settings missing in the instrumentation CR
+	defaults configv1alpha1.InstrumentationDefaults
+
+	reconciled []v1alpha1.WorkloadReference
+	changes    []v1alpha1.AuditChange
+	stale      []v1alpha1.WorkloadReference
+	failures   []v1alpha1.WorkloadFailure
+	// throttled is set when an update was postponed by the rollout gate
+	throttled    bool
+	requeueAfter time.Duration
+}
+
+// newWorkloadWriter creates a writer for the workloads of the instrumentation CR, the CR can be nil.
+func newWorkloadWriter(c client.Client, recorder record.EventRecorder, gate *RolloutGate, opts Options, instrumentation *v1alpha1.OpenTelemetryInstrumentation) *workloadWriter {
+	w := &workloadWriter{
+		client:          c,
+		recorder:        recorder,
+		audit:           opts.auditMode(instrumentation),
+		policy:          v1alpha1.RolloutImmediate,
+		gate:            gate,
+		opts:            opts,
+		optInAnnotation: opts.OptIn.Annotation,
+		defaults:        opts.Defaults,
+		instrumentation: instrumentation,
+	}
+	if instrumentation != nil && instrumentation.Spec.RolloutPolicy != "" {
+		w.policy = instrumentation.Spec.RolloutPolicy
+	}
+	return w
+}
+
+// inject injects the instrumentation into the Deployment pod template and updates the Deployment.
+// A Deployment the configuration cannot be rendered for is left untouched, the problem is reported
+// in the CR status by OpenTelemetryInstrumentationReconciler.
+// The instrumentation of a Deployment whose debugging session expired is injected from scratch and
+// is not deferred by the rollout policy. Canary Deployments are instrumented per pod by PodInjector,
+// their pod template is kept clean.
+func (w *workloadWriter) inject(ctx context.Context, ns *corev1.Namespace, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) (err error) {
+	ctx, span := startWriterSpan(ctx, "inject", dep)
+	defer func() { endSpan(span, err) }()
+	if _, canary := inject.CanaryPercent(dep.Spec.Template.ObjectMeta); canary {
+		logDecision(ctx, dep, decisionCanary, nil)
+		return w.clean(ctx, dep)
+	}
+	original := dep.DeepCopy()
+	_, expired := dep.Annotations[annotationSessionExpired]
+	if expired {
+		inject.Clean(&dep.Spec.Template.Spec)
+	}
+	workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+	if err := inject.InjectPod(ns.ObjectMeta, workload, &dep.Spec.Template.Spec, sessionSpec(inject.WithDefaults(instrumentation.Spec, w.defaults), dep)); err != nil {
+		workloadLogger(ctx, "Deployment", dep).Error(err, "cannot inject instrumentation", "decision", decisionInvalidConfiguration)
+		setDecision(ctx, decisionInvalidConfiguration)
+		w.event(dep, corev1.EventTypeWarning, reasonInvalidConfiguration, "Instrumentation cannot be injected: "+err.Error())
+		return nil
+	}
+	if !w.audit && !expired && inject.IsInjected(&original.Spec.Template.Spec) {
+		if deferred, err := w.deferUpdate(ctx, original, dep); deferred || err != nil {
+			return err
+		}
+	}
+	return w.update(ctx, original, dep, actionInject)
+}
+
+// clean removes the instrumentation from the Deployment pod template and updates the Deployment
+// if anything was removed.
+func (w *workloadWriter) clean(ctx context.Context, dep *v1.Deployment) (err error) {
+	ctx, span := startWriterSpan(ctx, "clean", dep)
+	defer func() { endSpan(span, err) }()
+	original := dep.DeepCopy()
+	inject.Clean(&dep.Spec.Template.Spec)
+	return w.update(ctx, original, dep, actionClean)
+}
+
+// cleanExcluded removes the instrumentation from a Deployment in an excluded namespace.
+// The exclusion pattern is logged when the Deployment carries any instrumentation.
+func (w *workloadWriter) cleanExcluded(ctx context.Context, dep *v1.Deployment, pattern string) error {
+	logger := workloadLogger(ctx, "Deployment", dep)
+	if inject.HasMarkers(&dep.Spec.Template.Spec) {
+		logger.Info("namespace is excluded from instrumentation, removing the instrumentation", "pattern", pattern)
+	} else {
+		logger.V(1).Info("namespace is excluded from instrumentation", "pattern", pattern)
+	}
+	return w.clean(ctx, dep)
+}
+
+// rollback removes the instrumentation from a failed Deployment and blocks it from being instrumented again.
+// The rollback is not limited by the rollout gate.
+func (w *workloadWriter) rollback(ctx context.Context, dep *v1.Deployment, failure string) (err error) {
+	ctx, span := startWriterSpan(ctx, "rollback", dep)
+	defer func() { endSpan(span, err) }()
+	inject.Clean(&dep.Spec.Template.Spec)
+	if dep.Annotations == nil {
+		dep.Annotations = map[string]string{}
+	}
+	dep.Annotations[annotationBlocked] = failure
+	delete(dep.Annotations, annotationPendingChanges)
+	delete(dep.Annotations, annotationApplyPending)
+	delete(dep.Annotations, annotationTemplateHash)
+	delete(dep.Annotations, annotationSessionExpired)
+	delete(dep.Annotations, annotationRolloutGeneration)
+	if err := w.client.Update(ctx, dep); err != nil {
+		return w.updateFailed(dep, err)
+	}
+
+	logDecision(ctx, dep, decisionRolledBack, nil, "failure", failure)
+	w.event(dep, corev1.EventTypeWarning, reasonRolledBack, "Instrumentation rolled back: "+failure)
+	w.reconciled = append(w.reconciled, v1alpha1.WorkloadReference{Kind: "Deployment", Name: dep.Name})
+	w.recordFailure(dep)
+	return nil
+}
+
+// recordFailure records the failure of a blocked Deployment for the CR status.
+func (w *workloadWriter) recordFailure(dep *v1.Deployment) {
+	if failure, blocked := dep.Annotations[annotationBlocked]; blocked {
+		w.failures = append(w.failures, v1alpha1.WorkloadFailure{
+			Workload: v1alpha1.WorkloadReference{Kind: "Deployment", Name: dep.Name},
+			Message:  failure,
+			Time:     metav1.Now(),
+		})
+	}
+}
+
+// deferUpdate decides whether the configuration change of an instrumented Deployment waits for the rollout
+// policy. The deferred change is recorded in the pending-changes annotation of the Deployment.
+// With the OnNextRollout policy the change cannot be part of the update of the user, the operator sees the changed
+// pod template only after the API server accepted it and the deployment controller started the rollout; changing
+// the Deployment at admission would need a Deployment webhook. The change is applied right away instead, the deployment
+// controller rolls the pods of the started rollout over to the instrumented ReplicaSet without waiting for it.
+func (w *workloadWriter) deferUpdate(ctx context.Context, original, dep *v1.Deployment) (bool, error) {
+	if equality.Semantic.DeepEqual(original.Spec.Template, dep.Spec.Template) {
+		return false, nil
+	}
+	switch w.policy {
+	case v1alpha1.RolloutOnNextRollout:
+		hash, ok := original.Annotations[annotationTemplateHash]
+		if ok && hash != templateHash(&original.Spec.Template) {
+			// the pod template has been changed by someone else, the workload is rolled out anyway
+			return false, nil
+		}
+	case v1alpha1.RolloutManual:
+		if original.Annotations[annotationApplyPending] == "true" {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	w.reconciled = append(w.reconciled, v1alpha1.WorkloadReference{Kind: "Deployment", Name: dep.Name})
+	w.stale = append(w.stale, v1alpha1.WorkloadReference{Kind: "Deployment", Name: dep.Name})
+	diff := inject.Diff(&original.Spec.Template.Spec, &dep.Spec.Template.Spec)
+	set := map[string]string{annotationPendingChanges: strings.Join(diff, ", ")}
+	if w.policy == v1alpha1.RolloutOnNextRollout {
+		set[annotationTemplateHash] = templateHash(&original.Spec.Template)
+	}
+	logDecision(ctx, dep, decisionDeferred, diff, "rolloutPolicy", w.policy)
+	return true, w.patchAnnotations(ctx, original, set)
+}
+
+func (w *workloadWriter) update(ctx context.Context, original, dep *v1.Deployment, action string) error {
+	workload := v1alpha1.WorkloadReference{Kind: "Deployment", Name: dep.Name}
+	w.reconciled = append(w.reconciled, workload)
+	w.recordFailure(original)
+	if equality.Semantic.DeepEqual(original.Spec.Template, dep.Spec.Template) {
+		logDecision(ctx, dep, decisionUnchanged, nil, "action", action)
+		if w.audit {
+			return nil
+		}
+		return w.syncAnnotations(ctx, original)
+	}
+
+	diff := inject.Diff(&original.Spec.Template.Spec, &dep.Spec.Template.Spec)
+	if !w.audit {
+		allowed, err := w.gate.allow(ctx, w.client, w.opts, dep)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			w.throttled = true
+			logDecision(ctx, dep, decisionThrottled, diff, "action", action,
+				"reason", "too many rollouts in progress or previous wave not completed")
+			return nil
+		}
+
+		delete(dep.Annotations, annotationPendingChanges)
+		delete(dep.Annotations, annotationApplyPending)
+		delete(dep.Annotations, annotationSessionExpired)
+		if action == actionInject {
+			// the update of the pod template increments the generation
+			metav1.SetMetaDataAnnotation(&dep.ObjectMeta, annotationRolloutGeneration, strconv.FormatInt(dep.Generation+1, 10))
+		} else {
+			delete(dep.Annotations, annotationRolloutGeneration)
+		}
+		// the owner is recorded by the update which injects the instrumentation
+		if w.owned(dep) {
+			metav1.SetMetaDataAnnotation(&dep.ObjectMeta, InstanceLabel, w.opts.instance())
+		} else {
+			delete(dep.Annotations, InstanceLabel)
+		}
+		if err := w.client.Update(ctx, dep); err != nil {
+			w.gate.release(dep)
+			return w.updateFailed(dep, err)
+		}
+		w.gate.started(dep)
+		logDecision(ctx, dep, decisionUpdated, diff, "action", action)
+		if action == actionClean {
+			cleanupsTotal.WithLabelValues(dep.Namespace, "Deployment").Inc()
+			w.event(dep, corev1.EventTypeNormal, reasonCleaned, "Instrumentation removed")
+		} else {
+			injectionsTotal.WithLabelValues(dep.Namespace, "Deployment").Inc()
+			w.event(dep, corev1.EventTypeNormal, reasonInjected, "Instrumentation injected: "+strings.Join(diff, ", "))
+		}
+		// the hash is computed from the pod template defaulted by the API server
+		return w.syncAnnotations(ctx, dep)
+	}
+
+	change := v1alpha1.AuditChange{
+		Workload: workload,
+		Action:   action,
+		Diff:     strings.Join(diff, "\n"),
+		Time:     metav1.Now(),
+	}
+	if err := w.client.Update(ctx, dep, client.DryRunAll); err != nil {
+		if errors.IsConflict(err) {
+			return err
+		}
+		change.Error = err.Error()
+		countError(reasonAuditFailed)
+		w.recorder.Event(dep, corev1.EventTypeWarning, reasonAuditFailed,
+			truncate(fmt.Sprintf("%s would be rejected: %v", action, err)))
+	} else {
+		w.recorder.Event(dep, corev1.EventTypeNormal, reasonAudit,
+			truncate(fmt.Sprintf("%s would change: %s", action, strings.Join(diff, ", "))))
+	}
+	w.changes = append(w.changes, change)
+	logDecision(ctx, dep, decisionAudited, diff, "action", action, "error", change.Error)
+	return nil
+}
+
+// event emits the event on the Deployment and on the instrumentation CR.
+func (w *workloadWriter) event(dep *v1.Deployment, eventType, reason, message string) {
+	if eventType == corev1.EventTypeWarning {
+		countError(reason)
+	}
+	w.recorder.Event(dep, eventType, reason, truncate(message))
+	if w.instrumentation != nil {
+		w.recorder.Event(w.instrumentation, eventType, reason, truncate(fmt.Sprintf("Deployment %s: %s", dep.Name, message)))
+	}
+}
+
+// updateFailed reports a conflicting update of the Deployment, the error is returned to retry the reconciliation.
+func (w *workloadWriter) updateFailed(dep *v1.Deployment, err error) error {
+	if errors.IsConflict(err) {
+		countError(reasonConflict)
+		w.recorder.Event(dep, corev1.EventTypeWarning, reasonConflict, truncate("Deployment was modified concurrently, retrying: "+err.Error()))
+	}
+	return err
+}
+
+// syncAnnotations removes the rollout policy annotations of a Deployment without pending changes
+// and the expired debugging session of a Deployment whose pod template is up to date.
+// With the OnNextRollout policy the hash of the current pod template is recorded.
+// An instrumented or canary Deployment is marked as owned by the operator instance.
+func (w *workloadWriter) syncAnnotations(ctx context.Context, dep *v1.Deployment) error {
+	set := map[string]string{}
+	remove := []string{annotationPendingChanges, annotationApplyPending, annotationSessionExpired}
+	injected := inject.IsInjected(&dep.Spec.Template.Spec)
+	if w.policy == v1alpha1.RolloutOnNextRollout && injected {
+		set[annotationTemplateHash] = templateHash(&dep.Spec.Template)
+	} else {
+		remove = append(remove, annotationTemplateHash)
+	}
+	if w.owned(dep) {
+		set[InstanceLabel] = w.opts.instance()
+	} else {
+		remove = append(remove, InstanceLabel)
+	}
+	return w.patchAnnotations(ctx, dep, set, remove...)
+}
+
+// owned returns true if the Deployment carries the instrumentation or it is a canary Deployment instrumented
+// by the webhook, the operator instance is recorded as its owner.
+func (w *workloadWriter) owned(dep *v1.Deployment) bool {
+	_, canary := inject.CanaryPercent(dep.Spec.Template.ObjectMeta)
+	return canary || inject.HasMarkers(&dep.Spec.Template.Spec)
+}
+
+// patchAnnotations sets and removes the Deployment annotations, the pod template is not changed.
+func (w *workloadWriter) patchAnnotations(ctx context.Context, dep *v1.Deployment, set map[string]string, remove ...string) error {
+	patched := dep.DeepCopy()
+	if patched.Annotations == nil {
+		patched.Annotations = map[string]string{}
+	}
+	for k, v := range set {
+		patched.Annotations[k] = v
+	}
+	for _, k := range remove {
+		delete(patched.Annotations, k)
+	}
+	if equality.Semantic.DeepEqual(dep.Annotations, patched.Annotations) ||
+		(len(dep.Annotations) == 0 && len(patched.Annotations) == 0) {
+		return nil
+	}
+	return w.client.Patch(ctx, patched, client.MergeFrom(dep))
+}
+
+// setStatus replaces the audit changes, stale workloads and failures of the reconciled workloads in the CR status.
+// The time of an audit change or failure which has already been recorded is preserved.
+func (w *workloadWriter) setStatus(status *v1alpha1.OpenTelemetryInstrumentationStatus) {
+	var changes []v1alpha1.AuditChange
+	for _, existing := range status.AuditChanges {
+		if !containsWorkload(w.reconciled, existing.Workload) {
+			changes = append(changes, existing)
+		}
+	}
+	for _, change := range w.changes {
+		for _, existing := range status.AuditChanges {
+			if existing.Workload == change.Workload && existing.Action == change.Action &&
+				existing.Diff == change.Diff && existing.Error == change.Error {
+				change.Time = existing.Time
+			}
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Workload.Name < changes[j].Workload.Name
+	})
+	status.AuditChanges = changes
+
+	var stale []v1alpha1.WorkloadReference
+	for _, existing := range status.StaleWorkloads {
+		if !containsWorkload(w.reconciled, existing) {
+			stale = append(stale, existing)
+		}
+	}
+	stale = append(stale, w.stale...)
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Name < stale[j].Name
+	})
+	status.StaleWorkloads = stale
+
+	var failures []v1alpha1.WorkloadFailure
+	for _, existing := range status.Failures {
+		if !containsWorkload(w.reconciled, existing.Workload) {
+			failures = append(failures, existing)
+		}
+	}
+	for _, failure := range w.failures {
+		for _, existing := range status.Failures {
+			if existing.Workload == failure.Workload && existing.Message == failure.Message {
+				failure.Time = existing.Time
+			}
+		}
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Workload.Name < failures[j].Workload.Name
+	})
+	status.Failures = failures
+}
+
+// requeue schedules the next reconciliation, the earliest requested time wins.
+func (w *workloadWriter) requeue(after time.Duration) {
+	if w.requeueAfter == 0 || after < w.requeueAfter {
+		w.requeueAfter = after
+	}
+}
+
+// result returns the reconcile result, throttled updates are retried later.
+func (w *workloadWriter) result() ctrl.Result {
+	if w.throttled {
+		w.requeue(rolloutRequeueInterval)
+	}
+	return ctrl.Result{RequeueAfter: w.requeueAfter}
+}
+
+// updateStatus writes the audit changes, stale workloads and failures to the CR status if they changed.
+func (w *workloadWriter) updateStatus(ctx context.Context, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+	if instrumentation == nil {
+		return nil
+	}
+	original := instrumentation.Status.DeepCopy()
+	w.setStatus(&instrumentation.Status)
+	if equality.Semantic.DeepEqual(original, &instrumentation.Status) {
+		return nil
+	}
+	return w.client.Status().Update(ctx, instrumentation)
+}
+
+// templateHash returns a short hash of the pod template.
+func templateHash(template *corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+func containsWorkload(workloads []v1alpha1.WorkloadReference, workload v1alpha1.WorkloadReference) bool {
+	for _, w := range workloads {
+		if w == workload {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(message string) string {
+	if len(message) > maxEventMessageLength {
+		return message[:maxEventMessageLength-3] + "..."
+	}
+	return message
+}
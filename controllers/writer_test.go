@@ -0,0 +1,354 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// instrumentedDeployment returns a Deployment instrumented by the spec.
+func instrumentedDeployment(t *testing.T, spec v1alpha1.OpenTelemetryInstrumentationSpec, annotations map[string]string) *v1.Deployment {
+	t.Helper()
+	dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop", Annotations: annotations}}
+	dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "backend:1"}}
+	workload := inject.Workload{Kind: "Deployment", ObjectMeta: dep.ObjectMeta}
+	if err := inject.InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, &dep.Spec.Template.Spec, spec); err != nil {
+		t.Fatal(err)
+	}
+	return dep
+}
+
+// recordedEvents drains the recorder and returns the type and reason of the events.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			fields := strings.SplitN(event, " ", 3)
+			events = append(events, fields[0]+" "+fields[1])
+		default:
+			return events
+		}
+	}
+}
+
+func TestDeferUpdate(t *testing.T) {
+	current := v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1", TracesSampler: "always_off"}
+	changed := v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1", TracesSampler: "always_on"}
+	// the hash of the template the operator has seen last
+	seen := templateHash(&instrumentedDeployment(t, current, nil).Spec.Template)
+
+	tests := []struct {
+		name        string
+		policy      v1alpha1.RolloutPolicy
+		annotations map[string]string
+		// userChange changes the pod template after the operator has seen it
+		userChange bool
+		applied    bool
+		stale      bool
+	}{
+		{
+			name:    "immediate",
+			policy:  v1alpha1.RolloutImmediate,
+			applied: true,
+		},
+		{
+			name:   "on next rollout, first seen",
+			policy: v1alpha1.RolloutOnNextRollout,
+			stale:  true,
+		},
+		{
+			name:        "on next rollout, template not changed",
+			policy:      v1alpha1.RolloutOnNextRollout,
+			annotations: map[string]string{annotationTemplateHash: seen},
+			stale:       true,
+		},
+		{
+			name:        "on next rollout, template changed",
+			policy:      v1alpha1.RolloutOnNextRollout,
+			annotations: map[string]string{annotationTemplateHash: seen},
+			userChange:  true,
+			applied:     true,
+		},
+		{
+			name:   "manual, not approved",
+			policy: v1alpha1.RolloutManual,
+			stale:  true,
+		},
+		{
+			name:        "manual, approved",
+			policy:      v1alpha1.RolloutManual,
+			annotations: map[string]string{annotationApplyPending: "true", annotationPendingChanges: "env app/OTEL_TRACES_SAMPLER"},
+			applied:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dep := instrumentedDeployment(t, current, test.annotations)
+			if test.userChange {
+				dep.Spec.Template.Spec.Containers[0].Image = "backend:2"
+			}
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(dep).Build()
+			instrumentation := &v1alpha1.OpenTelemetryInstrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: instrumentationName, Namespace: "shop"},
+				Spec:       changed,
+			}
+			instrumentation.Spec.RolloutPolicy = test.policy
+			recorder := record.NewFakeRecorder(10)
+			w := newWorkloadWriter(c, recorder, NewRolloutGate(), Options{}, instrumentation)
+			ctx := context.Background()
+			if err := w.inject(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, dep.DeepCopy(), instrumentation); err != nil {
+				t.Fatal(err)
+			}
+			var expectedEvents []string
+			if test.applied {
+				// on the Deployment and on the CR
+				expectedEvents = []string{"Normal " + reasonInjected, "Normal " + reasonInjected}
+			}
+			if events := recordedEvents(recorder); !reflect.DeepEqual(events, expectedEvents) {
+				t.Errorf("expected the events %v, got %v", expectedEvents, events)
+			}
+
+			stored := &v1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(dep), stored); err != nil {
+				t.Fatal(err)
+			}
+			sampler := ""
+			for _, env := range stored.Spec.Template.Spec.Containers[0].Env {
+				if env.Name == "OTEL_TRACES_SAMPLER" {
+					sampler = env.Value
+				}
+			}
+			if applied := sampler == changed.TracesSampler; applied != test.applied {
+				t.Errorf("expected applied %v, got the sampler %q", test.applied, sampler)
+			}
+			if stale := containsWorkload(w.stale, v1alpha1.WorkloadReference{Kind: "Deployment", Name: "backend"}); stale != test.stale {
+				t.Errorf("expected stale %v, got %v", test.stale, stale)
+			}
+			if _, pending := stored.Annotations[annotationPendingChanges]; pending != test.stale {
+				t.Errorf("expected pending changes %v, got %v", test.stale, stored.Annotations)
+			}
+			if _, approved := stored.Annotations[annotationApplyPending]; approved {
+				t.Errorf("expected the approval to be removed, got %v", stored.Annotations)
+			}
+			hash, hashed := stored.Annotations[annotationTemplateHash]
+			if hashed != (test.policy == v1alpha1.RolloutOnNextRollout) {
+				t.Errorf("expected the template hash only with the OnNextRollout policy, got %v", stored.Annotations)
+			}
+			if hashed && hash != templateHash(&stored.Spec.Template) {
+				t.Errorf("expected the hash of the current template %q, got %q", templateHash(&stored.Spec.Template), hash)
+			}
+		})
+	}
+}
+
+func TestSyncAnnotations(t *testing.T) {
+	spec := v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}
+	plain := func(annotations map[string]string) *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop", Annotations: annotations}}
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app"}}
+		return dep
+	}
+	stale := map[string]string{annotationPendingChanges: "env app/OTEL_TRACES_SAMPLER", annotationApplyPending: "true", annotationTemplateHash: "0123"}
+
+	tests := []struct {
+		name     string
+		policy   v1alpha1.RolloutPolicy
+		dep      *v1.Deployment
+		expected []string
+	}{
+		{
+			name:     "instrumented, immediate",
+			policy:   v1alpha1.RolloutImmediate,
+			dep:      instrumentedDeployment(t, spec, stale),
+			expected: []string{InstanceLabel},
+		},
+		{
+			name:     "instrumented, on next rollout",
+			policy:   v1alpha1.RolloutOnNextRollout,
+			dep:      instrumentedDeployment(t, spec, stale),
+			expected: []string{InstanceLabel, annotationTemplateHash},
+		},
+		{
+			name:   "not instrumented",
+			policy: v1alpha1.RolloutOnNextRollout,
+			dep:    plain(map[string]string{InstanceLabel: DefaultInstanceID, annotationTemplateHash: "0123"}),
+		},
+		{
+			name:   "canary",
+			policy: v1alpha1.RolloutImmediate,
+			dep: func() *v1.Deployment {
+				d := plain(nil)
+				d.Spec.Template.Labels = map[string]string{inject.CanaryPercentLabel: "10"}
+				return d
+			}(),
+			expected: []string{InstanceLabel},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(test.dep).Build()
+			instrumentation := &v1alpha1.OpenTelemetryInstrumentation{Spec: v1alpha1.OpenTelemetryInstrumentationSpec{RolloutPolicy: test.policy}}
+			w := newWorkloadWriter(c, record.NewFakeRecorder(10), NewRolloutGate(), Options{}, instrumentation)
+			ctx := context.Background()
+			if err := w.syncAnnotations(ctx, test.dep.DeepCopy()); err != nil {
+				t.Fatal(err)
+			}
+			stored := &v1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(test.dep), stored); err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.Annotations) != len(test.expected) {
+				t.Fatalf("expected the annotations %v, got %v", test.expected, stored.Annotations)
+			}
+			for _, k := range test.expected {
+				if _, ok := stored.Annotations[k]; !ok {
+					t.Errorf("expected the annotations %v, got %v", test.expected, stored.Annotations)
+				}
+			}
+			if hash, ok := stored.Annotations[annotationTemplateHash]; ok && hash != templateHash(&stored.Spec.Template) {
+				t.Errorf("expected the hash of the current template, got %q", hash)
+			}
+		})
+	}
+}
+
+func TestWriterEvents(t *testing.T) {
+	spec := v1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}
+	plain := func() *v1.Deployment {
+		dep := &v1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop"}}
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: "backend:1"}}
+		return dep
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+
+	tests := []struct {
+		name  string
+		dep   *v1.Deployment
+		spec  v1alpha1.OpenTelemetryInstrumentationSpec
+		opts  Options
+		write func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error
+		// expected are the types and reasons of the events, on the Deployment and then on the CR
+		expected []string
+		err      bool
+	}{
+		{
+			name: "injected",
+			dep:  plain(),
+			spec: spec,
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+				return w.inject(ctx, ns, dep, instrumentation)
+			},
+			expected: []string{"Normal " + reasonInjected, "Normal " + reasonInjected},
+		},
+		{
+			name: "already injected",
+			dep:  instrumentedDeployment(t, spec, nil),
+			spec: spec,
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+				return w.inject(ctx, ns, dep, instrumentation)
+			},
+		},
+		{
+			name: "cleaned",
+			dep:  instrumentedDeployment(t, spec, nil),
+			spec: spec,
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, _ *v1alpha1.OpenTelemetryInstrumentation) error {
+				return w.clean(ctx, dep)
+			},
+			expected: []string{"Normal " + reasonCleaned, "Normal " + reasonCleaned},
+		},
+		{
+			name: "rolled back",
+			dep:  instrumentedDeployment(t, spec, nil),
+			spec: spec,
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, _ *v1alpha1.OpenTelemetryInstrumentation) error {
+				return w.rollback(ctx, dep, "CrashLoopBackOff: container app of pod backend-1")
+			},
+			expected: []string{"Warning " + reasonRolledBack, "Warning " + reasonRolledBack},
+		},
+		{
+			name: "invalid configuration",
+			dep:  plain(),
+			spec: v1alpha1.OpenTelemetryInstrumentationSpec{
+				JavaagentImage:     "agent:1",
+				ResourceAttributes: map[string]string{"service.name": "{{ slice .Workload.Name 0 8 }}"},
+			},
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+				return w.inject(ctx, ns, dep, instrumentation)
+			},
+			expected: []string{"Warning " + reasonInvalidConfiguration, "Warning " + reasonInvalidConfiguration},
+		},
+		{
+			name: "audit",
+			dep:  plain(),
+			spec: spec,
+			opts: Options{DryRun: true},
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+				return w.inject(ctx, ns, dep, instrumentation)
+			},
+			// only on the Deployment, the CR status lists the changes
+			expected: []string{"Normal " + reasonAudit},
+		},
+		{
+			name: "conflict",
+			dep:  plain(),
+			spec: spec,
+			write: func(ctx context.Context, w *workloadWriter, dep *v1.Deployment, instrumentation *v1alpha1.OpenTelemetryInstrumentation) error {
+				dep.ResourceVersion = "1"
+				return w.inject(ctx, ns, dep, instrumentation)
+			},
+			expected: []string{"Warning " + reasonConflict},
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(test.dep).Build()
+			instrumentation := &v1alpha1.OpenTelemetryInstrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: instrumentationName, Namespace: "shop"},
+				Spec:       test.spec,
+			}
+			recorder := record.NewFakeRecorder(10)
+			w := newWorkloadWriter(c, recorder, NewRolloutGate(), test.opts, instrumentation)
+			dep := &v1.Deployment{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(test.dep), dep); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.write(context.Background(), w, dep, instrumentation); (err != nil) != test.err {
+				t.Fatalf("expected an error %v, got %v", test.err, err)
+			}
+			if events := recordedEvents(recorder); !reflect.DeepEqual(events, test.expected) {
+				t.Errorf("expected the events %v, got %v", test.expected, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+#!/usr/bin/env bash
+# Generates a Role and a RoleBinding per watched namespace from the generated manager ClusterRole,
+# for running the operator with --watch-namespaces without cluster-wide permissions.
+#
+# Usage: hack/namespaced-rbac.sh <namespace,...> [operator namespace] [service account]
+set -euo pipefail
+
+namespaces=${1:?comma separated list of the watched namespaces is required}
+operator_namespace=${2:-opentelemetry-instrumentation-operator-system}
+service_account=${3:-opentelemetry-instrumentation-operator-controller-manager}
+role_file="$(dirname "$0")/../config/rbac/role.yaml"
+
+# rules of the ClusterRole without the rules of cluster-scoped resources
+rules=$(awk '
+  /^rules:/ { in_rules = 1; next }
+  !in_rules { next }
+  /^- / { if (block != "" && block !~ /- namespaces\n/) printf "%s", block; block = "" }
+  { block = block $0 "\n" }
+  END { if (block != "" && block !~ /- namespaces\n/) printf "%s", block }
+' "$role_file")
+
+for namespace in ${namespaces//,/ }; do
+  cat <<YAML
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: opentelemetry-instrumentation-operator-manager-role
+  namespace: ${namespace}
+rules:
+${rules}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: opentelemetry-instrumentation-operator-manager-rolebinding
+  namespace: ${namespace}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: opentelemetry-instrumentation-operator-manager-role
+subjects:
+- kind: ServiceAccount
+  name: ${service_account}
+  namespace: ${operator_namespace}
+YAML
+done
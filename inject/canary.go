@@ -0,0 +1,38 @@
+package inject
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CanaryPercentLabel on the pod template limits the instrumentation to the given percentage
+	// of the new pods, the pods are instrumented by the webhook. The pods inherit the label,
+	// the webhook receives only the pods carrying it.
+	CanaryPercentLabel = "instrumentation.opentelemetry.io/canary-percent"
+	// InjectedLabel marks whether the webhook instrumented a canary pod, true or false.
+	InjectedLabel = "instrumentation.opentelemetry.io/injected"
+)
+
+// CanaryPercent returns the canary percentage of the pod template or pod. The second value is false
+// if the label is not set or it is not a number between 0 and 100.
+func CanaryPercent(meta metav1.ObjectMeta) (int, bool) {
+	value, ok := meta.Labels[CanaryPercentLabel]
+	if !ok {
+		return 0, false
+	}
+	percent, err := strconv.Atoi(value)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, false
+	}
+	return percent, true
+}
+
+// InCanary decides deterministically from the hash of the pod name whether the pod is instrumented.
+func InCanary(podName string, percent int) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(podName))
+	return int(h.Sum32()%100) < percent
+}
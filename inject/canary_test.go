@@ -0,0 +1,70 @@
+package inject
+
+import (
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCanaryPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		percent int
+		canary  bool
+	}{
+		{name: "not set"},
+		{name: "zero", labels: map[string]string{CanaryPercentLabel: "0"}, percent: 0, canary: true},
+		{name: "percent", labels: map[string]string{CanaryPercentLabel: "10"}, percent: 10, canary: true},
+		{name: "hundred", labels: map[string]string{CanaryPercentLabel: "100"}, percent: 100, canary: true},
+		{name: "above hundred", labels: map[string]string{CanaryPercentLabel: "101"}},
+		{name: "negative", labels: map[string]string{CanaryPercentLabel: "-1"}},
+		{name: "not a number", labels: map[string]string{CanaryPercentLabel: "ten"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			percent, canary := CanaryPercent(metav1.ObjectMeta{Labels: test.labels})
+			if percent != test.percent || canary != test.canary {
+				t.Errorf("expected (%d, %v), got (%d, %v)", test.percent, test.canary, percent, canary)
+			}
+		})
+	}
+
+	annotated := metav1.ObjectMeta{Annotations: map[string]string{CanaryPercentLabel: "10"}}
+	if _, canary := CanaryPercent(annotated); canary {
+		t.Error("an annotation must not enable the canary, the webhook cannot select it")
+	}
+}
+
+func TestInCanary(t *testing.T) {
+	for _, percent := range []int{0, 10, 50, 100} {
+		t.Run(fmt.Sprintf("%d%%", percent), func(t *testing.T) {
+			injected := 0
+			for i := 0; i < 1000; i++ {
+				name := fmt.Sprintf("backend-7d4b9c-%d", i)
+				in := InCanary(name, percent)
+				if in != InCanary(name, percent) {
+					t.Fatalf("pod %s is not chosen deterministically", name)
+				}
+				if in {
+					injected++
+				}
+			}
+			switch percent {
+			case 0:
+				if injected != 0 {
+					t.Errorf("expected no pods, got %d", injected)
+				}
+			case 100:
+				if injected != 1000 {
+					t.Errorf("expected all pods, got %d", injected)
+				}
+			default:
+				if expected := percent * 10; injected < expected-50 || injected > expected+50 {
+					t.Errorf("expected about %d pods, got %d", expected, injected)
+				}
+			}
+		})
+	}
+}
@@ -1,25 +1,34 @@
 package inject
 
 import (
-	"fmt"
-	corev1 "k8s.io/api/core/v1"
 	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
-func Clean(pod *corev1.PodSpec) bool {
-	initContainerIdx := -1
-	for i, c := range pod.InitContainers {
-		if c.Name == initContainerName {
-			fmt.Println(c.Name)
-			initContainerIdx = i
-			break
-		}
+// HasMarkers returns true if the pod contains any part of the instrumentation: the init container,
+// the volume or the agent in JAVA_TOOL_OPTIONS.
+func HasMarkers(pod *corev1.PodSpec) bool {
+	if getIndexOfContainer(pod.InitContainers, initContainerName) > -1 || getIndexOfVolume(pod.Volumes, volumeName) > -1 {
+		return true
 	}
-	if initContainerIdx == -1 {
+	if len(pod.Containers) < 1 {
 		return false
 	}
+	idx := getIndexOfEnv(pod.Containers[0].Env, envJavaToolsOptions)
+	return idx > -1 && strings.Contains(pod.Containers[0].Env[idx].Value, javaJVMArgument)
+}
 
-	pod.InitContainers = append(pod.InitContainers[:initContainerIdx], pod.InitContainers[initContainerIdx+1:]...)
+// Clean removes the instrumentation from the pod, also a partial one left by an interrupted update.
+// It returns false if the pod has no instrumentation markers.
+func Clean(pod *corev1.PodSpec) bool {
+	if !HasMarkers(pod) {
+		return false
+	}
+
+	if idx := getIndexOfContainer(pod.InitContainers, initContainerName); idx > -1 {
+		pod.InitContainers = append(pod.InitContainers[:idx], pod.InitContainers[idx+1:]...)
+	}
 	for i, v := range pod.Volumes {
 		if v.Name == volumeName {
 			pod.Volumes = append(pod.Volumes[:i], pod.Volumes[i+1:]...)
@@ -0,0 +1,117 @@
+package inject
+
+import (
+	"testing"
+
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClean(t *testing.T) {
+	plain := func() *corev1.PodSpec {
+		return &corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+			Containers: []corev1.Container{{
+				Name:         "app",
+				Env:          []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+				VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+			}},
+			Volumes: []corev1.Volume{{Name: "data"}},
+		}
+	}
+	injected := func() *corev1.PodSpec {
+		pod := plain()
+		workload := Workload{Kind: "Deployment", ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop"}}
+		spec := cachev1alpha1.OpenTelemetryInstrumentationSpec{
+			JavaagentImage:     "agent:1",
+			OTLPEndpoint:       "http://collector:4317",
+			ResourceAttributes: map[string]string{"team": "payments"},
+			TracesSampler:      "parentbased_traceidratio",
+			TracesSamplerArg:   "0.25",
+		}
+		if err := InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, pod, spec); err != nil {
+			t.Fatal(err)
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name    string
+		pod     *corev1.PodSpec
+		markers bool
+	}{
+		{
+			name: "not instrumented",
+			pod:  plain(),
+		},
+		{
+			name:    "instrumented",
+			pod:     injected(),
+			markers: true,
+		},
+		{
+			name: "init container only",
+			pod: func() *corev1.PodSpec {
+				pod := injected()
+				pod.Volumes = plain().Volumes
+				pod.Containers = plain().Containers
+				return pod
+			}(),
+			markers: true,
+		},
+		{
+			name: "volume only",
+			pod: func() *corev1.PodSpec {
+				pod := injected()
+				pod.InitContainers = plain().InitContainers
+				pod.Containers = plain().Containers
+				return pod
+			}(),
+			markers: true,
+		},
+		{
+			name: "agent and environment only",
+			pod: func() *corev1.PodSpec {
+				pod := injected()
+				pod.InitContainers = plain().InitContainers
+				pod.Volumes = plain().Volumes
+				pod.Containers[0].VolumeMounts = plain().Containers[0].VolumeMounts
+				return pod
+			}(),
+			markers: true,
+		},
+		{
+			name: "environment without the agent",
+			pod: func() *corev1.PodSpec {
+				pod := plain()
+				pod.Containers[0].Env = append(pod.Containers[0].Env, corev1.EnvVar{Name: envOTELServiceName, Value: "backend"})
+				return pod
+			}(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := test.pod.DeepCopy()
+			if markers := HasMarkers(test.pod); markers != test.markers {
+				t.Fatalf("expected HasMarkers %v, got %v", test.markers, markers)
+			}
+			if cleaned := Clean(test.pod); cleaned != test.markers {
+				t.Errorf("expected Clean to return %v, got %v", test.markers, cleaned)
+			}
+			if !test.markers {
+				if !equality.Semantic.DeepEqual(original, test.pod) {
+					t.Errorf("expected the pod without markers to be unchanged, got %v", Diff(original, test.pod))
+				}
+				return
+			}
+			if !equality.Semantic.DeepEqual(plain(), test.pod) {
+				t.Errorf("expected the plain pod, got %v", Diff(plain(), test.pod))
+			}
+			if HasMarkers(test.pod) || IsInjected(test.pod) {
+				t.Error("expected no markers after cleaning")
+			}
+		})
+	}
+}
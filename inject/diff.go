@@ -0,0 +1,99 @@
+package inject
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// Diff returns a summary of the changes between two pod specs, one line per change.
+// Added items are prefixed by +, removed by - and modified by ~.
+func Diff(before, after *corev1.PodSpec) []string {
+	var changes []string
+	changes = append(changes, diffContainers("initContainer", before.InitContainers, after.InitContainers)...)
+	changes = append(changes, diffVolumes(before.Volumes, after.Volumes)...)
+
+	for _, a := range after.Containers {
+		idx := getIndexOfContainer(before.Containers, a.Name)
+		if idx == -1 {
+			changes = append(changes, "+container "+a.Name)
+			continue
+		}
+		b := before.Containers[idx]
+		changes = append(changes, diffEnv(a.Name, b.Env, a.Env)...)
+		changes = append(changes, diffVolumeMounts(a.Name, b.VolumeMounts, a.VolumeMounts)...)
+	}
+	for _, b := range before.Containers {
+		if getIndexOfContainer(after.Containers, b.Name) == -1 {
+			changes = append(changes, "-container "+b.Name)
+		}
+	}
+	return changes
+}
+
+func diffContainers(kind string, before, after []corev1.Container) []string {
+	var changes []string
+	for _, a := range after {
+		idx := getIndexOfContainer(before, a.Name)
+		if idx == -1 {
+			changes = append(changes, fmt.Sprintf("+%s %s image=%s", kind, a.Name, a.Image))
+		} else if !equality.Semantic.DeepEqual(before[idx], a) {
+			changes = append(changes, fmt.Sprintf("~%s %s image=%s", kind, a.Name, a.Image))
+		}
+	}
+	for _, b := range before {
+		if getIndexOfContainer(after, b.Name) == -1 {
+			changes = append(changes, fmt.Sprintf("-%s %s", kind, b.Name))
+		}
+	}
+	return changes
+}
+
+func diffVolumes(before, after []corev1.Volume) []string {
+	var changes []string
+	for _, a := range after {
+		if getIndexOfVolume(before, a.Name) == -1 {
+			changes = append(changes, "+volume "+a.Name)
+		}
+	}
+	for _, b := range before {
+		if getIndexOfVolume(after, b.Name) == -1 {
+			changes = append(changes, "-volume "+b.Name)
+		}
+	}
+	return changes
+}
+
+func diffEnv(container string, before, after []corev1.EnvVar) []string {
+	var changes []string
+	for _, a := range after {
+		idx := getIndexOfEnv(before, a.Name)
+		if idx == -1 {
+			changes = append(changes, fmt.Sprintf("+env %s/%s=%s", container, a.Name, a.Value))
+		} else if before[idx].Value != a.Value {
+			changes = append(changes, fmt.Sprintf("~env %s/%s=%s (was %s)", container, a.Name, a.Value, before[idx].Value))
+		}
+	}
+	for _, b := range before {
+		if getIndexOfEnv(after, b.Name) == -1 {
+			changes = append(changes, fmt.Sprintf("-env %s/%s", container, b.Name))
+		}
+	}
+	return changes
+}
+
+func diffVolumeMounts(container string, before, after []corev1.VolumeMount) []string {
+	var changes []string
+	for _, a := range after {
+		if getIndexOfVolumeMount(before, a.Name) == -1 {
+			changes = append(changes, fmt.Sprintf("+volumeMount %s/%s=%s", container, a.Name, a.MountPath))
+		}
+	}
+	for _, b := range before {
+		if getIndexOfVolumeMount(after, b.Name) == -1 {
+			changes = append(changes, fmt.Sprintf("-volumeMount %s/%s", container, b.Name))
+		}
+	}
+	return changes
+}
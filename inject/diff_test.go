@@ -0,0 +1,90 @@
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiff(t *testing.T) {
+	pod := func() *corev1.PodSpec {
+		return &corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "app",
+			Env:  []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+		}}}
+	}
+	spec := cachev1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1", OTLPEndpoint: "http://collector:4317"}
+	workload := Workload{Kind: "Deployment", ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "shop"}}
+	injected := pod()
+	if err := InjectPod(metav1.ObjectMeta{Name: "shop"}, workload, injected, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		before, after *corev1.PodSpec
+		expected      []string
+	}{
+		{
+			name:   "unchanged",
+			before: pod(),
+			after:  pod(),
+		},
+		{
+			name:   "inject",
+			before: pod(),
+			after:  injected,
+			expected: []string{
+				"+initContainer opentelemetry-auto-instrumentation image=agent:1",
+				"+volume opentelemetry-auto-instrumentation",
+				"+env app/JAVA_TOOL_OPTIONS= -javaagent:/otel-auto-instrumentation/javaagent.jar",
+				"+env app/OTEL_EXPORTER_OTLP_ENDPOINT=http://collector:4317",
+				"+env app/OTEL_SERVICE_NAME=app",
+				"+volumeMount app/opentelemetry-auto-instrumentation=/otel-auto-instrumentation",
+			},
+		},
+		{
+			name:   "clean",
+			before: injected,
+			after:  pod(),
+			expected: []string{
+				"-initContainer opentelemetry-auto-instrumentation",
+				"-volume opentelemetry-auto-instrumentation",
+				"-env app/JAVA_TOOL_OPTIONS",
+				"-env app/OTEL_EXPORTER_OTLP_ENDPOINT",
+				"-env app/OTEL_SERVICE_NAME",
+				"-volumeMount app/opentelemetry-auto-instrumentation",
+			},
+		},
+		{
+			name:   "modified init container",
+			before: injected,
+			after: func() *corev1.PodSpec {
+				p := injected.DeepCopy()
+				p.InitContainers[0].Image = "agent:2"
+				return p
+			}(),
+			expected: []string{"~initContainer opentelemetry-auto-instrumentation image=agent:2"},
+		},
+		{
+			name:   "containers added and removed",
+			before: pod(),
+			after:  &corev1.PodSpec{Containers: []corev1.Container{{Name: "sidecar"}}},
+			expected: []string{
+				"+container sidecar",
+				"-container app",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := Diff(test.before, test.after)
+			if !reflect.DeepEqual(diff, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, diff)
+			}
+		})
+	}
+}
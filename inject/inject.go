@@ -1,7 +1,6 @@
 package inject
 
 import (
-	"fmt"
 	"strings"
 
 	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
@@ -23,18 +22,18 @@ const (
 	javaJVMArgument = " -javaagent:/otel-auto-instrumentation/javaagent.jar"
 )
 
-func IsInstrumentationEnabled(label string, meta ...metav1.ObjectMeta) bool {
-	for _, ometa := range meta {
-		val, ok := ometa.Labels[label]
-		if !ok {
-			continue
-		}
-		return val == "enabled"
-	}
-	return false
+// IsInjected returns true if the pod contains the instrumentation init container.
+func IsInjected(pod *corev1.PodSpec) bool {
+	return getIndexOfContainer(pod.InitContainers, initContainerName) > -1
 }
 
-func InjectPod(workloadMeta metav1.ObjectMeta, pod *corev1.PodSpec, instrumentation cachev1alpha1.OpenTelemetryInstrumentationSpec) {
+func InjectPod(ns metav1.ObjectMeta, workload Workload, pod *corev1.PodSpec, instrumentation cachev1alpha1.OpenTelemetryInstrumentationSpec) error {
+	// render the attributes first so a broken template leaves the pod untouched
+	resourceAttributes, err := renderResourceAttributes(instrumentation.ResourceAttributes, newTemplateContext(ns, workload, &pod.Containers[0]))
+	if err != nil {
+		return err
+	}
+
 	idx := getIndexOfContainer(pod.InitContainers, "opentelemetry-auto-instrumentation")
 	if idx == -1 {
 		pod.InitContainers = append(pod.InitContainers, corev1.Container{
@@ -47,6 +46,14 @@ func InjectPod(workloadMeta metav1.ObjectMeta, pod *corev1.PodSpec, instrumentat
 				MountPath: "/otel-auto-instrumentation",
 			}},
 		})
+		idx = len(pod.InitContainers) - 1
+	}
+	pod.InitContainers[idx].Image = instrumentation.JavaagentImage
+	if instrumentation.InitContainerResources != nil {
+		pod.InitContainers[idx].Resources = *instrumentation.InitContainerResources
+	}
+	if instrumentation.InitContainerSecurityContext != nil {
+		pod.InitContainers[idx].SecurityContext = instrumentation.InitContainerSecurityContext
 	}
 
 	idx = getIndexOfVolume(pod.Volumes, "opentelemetry-auto-instrumentation")
@@ -58,10 +65,11 @@ func InjectPod(workloadMeta metav1.ObjectMeta, pod *corev1.PodSpec, instrumentat
 			}})
 	}
 
-	injectContainer(workloadMeta, &pod.Containers[0], instrumentation)
+	injectContainer(workload.ObjectMeta, &pod.Containers[0], instrumentation, resourceAttributes)
+	return nil
 }
 
-func injectContainer(parentMeta metav1.ObjectMeta, container *corev1.Container, inst cachev1alpha1.OpenTelemetryInstrumentationSpec) {
+func injectContainer(parentMeta metav1.ObjectMeta, container *corev1.Container, inst cachev1alpha1.OpenTelemetryInstrumentationSpec, resourceAttributes string) {
 	idx := getIndexOfEnv(container.Env, envJavaToolsOptions)
 	if idx > -1 && strings.Contains(container.Env[idx].Value, javaJVMArgument) {
 		// nothing
@@ -98,13 +106,6 @@ func injectContainer(parentMeta metav1.ObjectMeta, container *corev1.Container,
 	}
 
 	if len(inst.ResourceAttributes) > 0 {
-		resourceAttributes := ""
-		for k, v := range inst.ResourceAttributes {
-			if resourceAttributes != "" {
-				resourceAttributes += ","
-			}
-			resourceAttributes += fmt.Sprintf("%s=%s", k, v)
-		}
 		resourceAttributes += ",k8s.namespace=" + parentMeta.Namespace
 		resourceAttributes += ",k8s.deployment=" + parentMeta.Name
 		//resourceAttributes += ",k8s.pod=" + metadata.podName
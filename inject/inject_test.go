@@ -0,0 +1,43 @@
+package inject
+
+import (
+	"testing"
+
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInjectPodUpdatesInitContainer(t *testing.T) {
+	pod := &corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}
+	workload := Workload{Kind: "Deployment", ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "shop"}}
+	ns := metav1.ObjectMeta{Name: "shop"}
+	if err := InjectPod(ns, workload, pod, cachev1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resources := &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}}
+	nonRoot := true
+	spec := cachev1alpha1.OpenTelemetryInstrumentationSpec{
+		JavaagentImage:               "agent:2",
+		InitContainerResources:       resources,
+		InitContainerSecurityContext: &corev1.SecurityContext{RunAsNonRoot: &nonRoot},
+	}
+	if err := InjectPod(ns, workload, pod, spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.InitContainers) != 1 {
+		t.Fatalf("expected one init container, got %d", len(pod.InitContainers))
+	}
+	initContainer := pod.InitContainers[0]
+	if initContainer.Image != "agent:2" {
+		t.Errorf("expected the image agent:2, got %s", initContainer.Image)
+	}
+	if !initContainer.Resources.Limits.Memory().Equal(resource.MustParse("64Mi")) {
+		t.Errorf("expected the memory limit 64Mi, got %v", initContainer.Resources.Limits)
+	}
+	if initContainer.SecurityContext == nil || initContainer.SecurityContext.RunAsNonRoot == nil || !*initContainer.SecurityContext.RunAsNonRoot {
+		t.Errorf("expected the security context to be set, got %v", initContainer.SecurityContext)
+	}
+}
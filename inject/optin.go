@@ -0,0 +1,81 @@
+package inject
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultOptInLabel is the label which enables the Java instrumentation.
+	DefaultOptInLabel = "opentelemetry-inst-java"
+	// DefaultOptInAnnotation is the annotation which enables the Java instrumentation,
+	// it follows the convention of the upstream OpenTelemetry operator.
+	DefaultOptInAnnotation = "instrumentation.opentelemetry.io/inject-java"
+)
+
+// OptIn holds the label and annotation keys which enable or disable the instrumentation.
+// An empty key is not evaluated.
+type OptIn struct {
+	Label      string
+	Annotation string
+}
+
+// DefaultOptIn returns the default opt-in label and annotation.
+func DefaultOptIn() OptIn {
+	return OptIn{
+		Label:      DefaultOptInLabel,
+		Annotation: DefaultOptInAnnotation,
+	}
+}
+
+// IsInstrumentationEnabled decides whether the instrumentation is enabled.
+// The objects are passed from the most to the least specific one - pod template, workload, namespace.
+// The first object with a valid opt-in value decides, on the same object the annotation
+// takes precedence over the label. Invalid values are ignored.
+func (o OptIn) IsInstrumentationEnabled(meta ...metav1.ObjectMeta) bool {
+	enabled, _ := o.Lookup(meta...)
+	return enabled
+}
+
+// Lookup is like IsInstrumentationEnabled, but it also reports whether any of the objects
+// explicitly enables or disables the instrumentation.
+func (o OptIn) Lookup(meta ...metav1.ObjectMeta) (enabled bool, ok bool) {
+	for _, ometa := range meta {
+		if enabled, ok := o.lookup(ometa); ok {
+			return enabled, true
+		}
+	}
+	return false, false
+}
+
+func (o OptIn) lookup(meta metav1.ObjectMeta) (enabled bool, ok bool) {
+	if o.Annotation != "" {
+		if val, found := meta.Annotations[o.Annotation]; found {
+			if enabled, err := ParseOptIn(val); err == nil {
+				return enabled, true
+			}
+		}
+	}
+	if o.Label != "" {
+		if val, found := meta.Labels[o.Label]; found {
+			if enabled, err := ParseOptIn(val); err == nil {
+				return enabled, true
+			}
+		}
+	}
+	return false, false
+}
+
+// ParseOptIn parses the value of an opt-in label or annotation.
+// Accepted values are true, enabled, false and disabled.
+func ParseOptIn(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "enabled":
+		return true, nil
+	case "false", "disabled":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid opt-in value %q, expected one of true, false, enabled, disabled", value)
+}
@@ -0,0 +1,133 @@
+package inject
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseOptIn(t *testing.T) {
+	tests := []struct {
+		value   string
+		enabled bool
+		err     bool
+	}{
+		{value: "true", enabled: true},
+		{value: "enabled", enabled: true},
+		{value: " Enabled ", enabled: true},
+		{value: "TRUE", enabled: true},
+		{value: "false"},
+		{value: "disabled"},
+		{value: "", err: true},
+		{value: "yes", err: true},
+		{value: "1", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			enabled, err := ParseOptIn(test.value)
+			if test.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if enabled != test.enabled {
+				t.Errorf("expected %v, got %v", test.enabled, enabled)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	label := func(value string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Labels: map[string]string{DefaultOptInLabel: value}}
+	}
+	annotation := func(value string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Annotations: map[string]string{DefaultOptInAnnotation: value}}
+	}
+	none := metav1.ObjectMeta{}
+
+	tests := []struct {
+		name                   string
+		optIn                  OptIn
+		template, workload, ns metav1.ObjectMeta
+		enabled, ok            bool
+	}{
+		{
+			name:  "not set",
+			optIn: DefaultOptIn(),
+		},
+		{
+			name:    "namespace label",
+			optIn:   DefaultOptIn(),
+			ns:      label("true"),
+			enabled: true,
+			ok:      true,
+		},
+		{
+			name:     "workload overrides namespace",
+			optIn:    DefaultOptIn(),
+			workload: label("false"),
+			ns:       label("true"),
+			ok:       true,
+		},
+		{
+			name:     "pod template overrides workload",
+			optIn:    DefaultOptIn(),
+			template: annotation("enabled"),
+			workload: label("disabled"),
+			ns:       label("disabled"),
+			enabled:  true,
+			ok:       true,
+		},
+		{
+			name:  "annotation overrides label on the same object",
+			optIn: DefaultOptIn(),
+			workload: metav1.ObjectMeta{
+				Labels:      map[string]string{DefaultOptInLabel: "true"},
+				Annotations: map[string]string{DefaultOptInAnnotation: "false"},
+			},
+			ok: true,
+		},
+		{
+			name:     "invalid value falls through to the next object",
+			optIn:    DefaultOptIn(),
+			template: label("yes"),
+			workload: none,
+			ns:       label("true"),
+			enabled:  true,
+			ok:       true,
+		},
+		{
+			name:  "invalid annotation falls back to the label",
+			optIn: DefaultOptIn(),
+			workload: metav1.ObjectMeta{
+				Labels:      map[string]string{DefaultOptInLabel: "true"},
+				Annotations: map[string]string{DefaultOptInAnnotation: "yes"},
+			},
+			enabled: true,
+			ok:      true,
+		},
+		{
+			name:  "empty label key is not evaluated",
+			optIn: OptIn{Annotation: DefaultOptInAnnotation},
+			ns:    label("true"),
+		},
+		{
+			name:     "custom keys",
+			optIn:    OptIn{Label: "otel", Annotation: "example.com/otel"},
+			template: label("false"),
+			workload: metav1.ObjectMeta{Annotations: map[string]string{"example.com/otel": "true"}},
+			enabled:  true,
+			ok:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enabled, ok := test.optIn.Lookup(test.template, test.workload, test.ns)
+			if enabled != test.enabled || ok != test.ok {
+				t.Errorf("expected (%v, %v), got (%v, %v)", test.enabled, test.ok, enabled, ok)
+			}
+			if test.optIn.IsInstrumentationEnabled(test.template, test.workload, test.ns) != test.enabled {
+				t.Errorf("IsInstrumentationEnabled does not match Lookup")
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package inject
+
+import (
+	"path"
+	"strings"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// BlockedAnnotation is set on a workload whose instrumentation was rolled back, the workload is not instrumented
+// until the annotation is removed.
+const BlockedAnnotation = "instrumentation.opentelemetry.io/blocked"
+
+// BuildTimeAnnotation marks a workload whose instrumentation was injected at build time, the operator does not
+// touch it.
+const BuildTimeAnnotation = "instrumentation.opentelemetry.io/build-time"
+
+// DefaultExcludedNamespaces are the namespaces which are never instrumented by default.
+var DefaultExcludedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// Policy decides which workloads are instrumented. It is shared by the operator and the build-time injection.
+type Policy struct {
+	// OptIn holds the label and annotation keys which enable the instrumentation.
+	OptIn OptIn
+	// ExcludedNamespaces are glob patterns (see path.Match) of namespaces which are never instrumented.
+	ExcludedNamespaces []string
+}
+
+// ExcludedNamespace returns the first exclusion pattern which matches the namespace.
+func (p Policy) ExcludedNamespace(namespace string) (string, bool) {
+	for _, pattern := range p.ExcludedNamespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Enabled decides whether the workload in the namespace is instrumented. Workloads in excluded namespaces
+// and blocked workloads are never instrumented. Explicit opt-in labels and annotations on the pod template,
+// workload or namespace take precedence over the selectors of the instrumentation CR spec, which can be nil.
+func (p Policy) Enabled(ns, workload, template metav1.ObjectMeta, spec *cachev1alpha1.OpenTelemetryInstrumentationSpec) bool {
+	if _, excluded := p.ExcludedNamespace(ns.Name); excluded {
+		return false
+	}
+	if _, blocked := workload.Annotations[BlockedAnnotation]; blocked {
+		return false
+	}
+	if enabled, ok := p.OptIn.Lookup(template, workload, ns); ok {
+		return enabled
+	}
+	return spec != nil && SelectorsMatch(*spec, ns, workload)
+}
+
+// WithConfig returns the policy overridden by the label keys and excluded namespaces of the configuration file
+// of the operator. The flags set on the command line take precedence over the file, explicit holds their names:
+// instrumentation-label, instrumentation-annotation and excluded-namespaces.
+func (p Policy) WithConfig(config *configv1alpha1.OperatorConfig, explicit map[string]bool) Policy {
+	if config.InstrumentationLabel != nil && !explicit["instrumentation-label"] {
+		p.OptIn.Label = *config.InstrumentationLabel
+	}
+	if config.InstrumentationAnnotation != nil && !explicit["instrumentation-annotation"] {
+		p.OptIn.Annotation = *config.InstrumentationAnnotation
+	}
+	if config.ExcludedNamespaces != nil && !explicit["excluded-namespaces"] {
+		p.ExcludedNamespaces = config.ExcludedNamespaces
+	}
+	return p
+}
+
+// SplitList splits a comma separated flag value, e.g. --excluded-namespaces, empty items are skipped.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SelectorsMatch returns true if the workload and namespace selectors of the CR spec match the workload.
+// At least one of the selectors has to be set.
+func SelectorsMatch(spec cachev1alpha1.OpenTelemetryInstrumentationSpec, ns, workload metav1.ObjectMeta) bool {
+	if spec.WorkloadSelector == nil && spec.NamespaceSelector == nil {
+		return false
+	}
+	return labelSelectorMatches(spec.NamespaceSelector, ns.Labels) && labelSelectorMatches(spec.WorkloadSelector, workload.Labels)
+}
+
+// labelSelectorMatches returns true for a nil selector and false for an invalid one.
+func labelSelectorMatches(selector *metav1.LabelSelector, objLabels map[string]string) bool {
+	if selector == nil {
+		return true
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(objLabels))
+}
+
+// WithDefaults fills the settings missing in the instrumentation CR spec from the defaults.
+func WithDefaults(spec cachev1alpha1.OpenTelemetryInstrumentationSpec, defaults configv1alpha1.InstrumentationDefaults) cachev1alpha1.OpenTelemetryInstrumentationSpec {
+	if spec.JavaagentImage == "" {
+		spec.JavaagentImage = defaults.Images[configv1alpha1.LanguageJava]
+	}
+	if spec.OTLPEndpoint == "" {
+		spec.OTLPEndpoint = defaults.OTLPEndpoint
+	}
+	if spec.InitContainerResources == nil {
+		spec.InitContainerResources = defaults.InitContainerResources
+	}
+	if spec.InitContainerSecurityContext == nil {
+		spec.InitContainerSecurityContext = defaults.InitContainerSecurityContext
+	}
+	return spec
+}
@@ -0,0 +1,121 @@
+package inject
+
+import (
+	"reflect"
+	"testing"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicyEnabled(t *testing.T) {
+	policy := Policy{OptIn: DefaultOptIn(), ExcludedNamespaces: []string{"kube-*"}}
+	optIn := map[string]string{DefaultOptInLabel: "true"}
+	selector := &cachev1alpha1.OpenTelemetryInstrumentationSpec{
+		WorkloadSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "backend"}},
+	}
+
+	tests := []struct {
+		name     string
+		ns       metav1.ObjectMeta
+		workload metav1.ObjectMeta
+		template metav1.ObjectMeta
+		spec     *cachev1alpha1.OpenTelemetryInstrumentationSpec
+		enabled  bool
+	}{
+		{
+			name: "no opt-in, no CR",
+			ns:   metav1.ObjectMeta{Name: "shop"},
+		},
+		{
+			name:    "namespace opt-in without a CR",
+			ns:      metav1.ObjectMeta{Name: "shop", Labels: optIn},
+			enabled: true,
+		},
+		{
+			name:     "workload selector",
+			ns:       metav1.ObjectMeta{Name: "shop"},
+			workload: metav1.ObjectMeta{Labels: map[string]string{"app": "backend"}},
+			spec:     selector,
+			enabled:  true,
+		},
+		{
+			name:     "opt-out over the selector",
+			ns:       metav1.ObjectMeta{Name: "shop"},
+			workload: metav1.ObjectMeta{Labels: map[string]string{"app": "backend"}},
+			template: metav1.ObjectMeta{Labels: map[string]string{DefaultOptInLabel: "false"}},
+			spec:     selector,
+		},
+		{
+			name: "CR without selectors",
+			ns:   metav1.ObjectMeta{Name: "shop"},
+			spec: &cachev1alpha1.OpenTelemetryInstrumentationSpec{},
+		},
+		{
+			name:     "excluded namespace",
+			ns:       metav1.ObjectMeta{Name: "kube-system", Labels: optIn},
+			workload: metav1.ObjectMeta{Labels: optIn},
+		},
+		{
+			name:     "blocked workload",
+			ns:       metav1.ObjectMeta{Name: "shop", Labels: optIn},
+			workload: metav1.ObjectMeta{Labels: optIn, Annotations: map[string]string{BlockedAnnotation: "rollout failed"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if enabled := policy.Enabled(test.ns, test.workload, test.template, test.spec); enabled != test.enabled {
+				t.Errorf("expected %v, got %v", test.enabled, enabled)
+			}
+		})
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	defaults := configv1alpha1.InstrumentationDefaults{
+		Images:       map[string]string{configv1alpha1.LanguageJava: "agent:default"},
+		OTLPEndpoint: "http://collector:4317",
+	}
+	spec := WithDefaults(cachev1alpha1.OpenTelemetryInstrumentationSpec{JavaagentImage: "agent:1"}, defaults)
+	if spec.JavaagentImage != "agent:1" {
+		t.Errorf("expected the image of the CR, got %q", spec.JavaagentImage)
+	}
+	if spec.OTLPEndpoint != defaults.OTLPEndpoint {
+		t.Errorf("expected the default endpoint, got %q", spec.OTLPEndpoint)
+	}
+	if spec = WithDefaults(cachev1alpha1.OpenTelemetryInstrumentationSpec{}, defaults); spec.JavaagentImage != "agent:default" {
+		t.Errorf("expected the default image, got %q", spec.JavaagentImage)
+	}
+}
+
+func TestPolicyWithConfig(t *testing.T) {
+	label := ""
+	config := &configv1alpha1.OperatorConfig{InstrumentationLabel: &label, ExcludedNamespaces: []string{"legacy-*"}}
+	flags := Policy{OptIn: DefaultOptIn(), ExcludedNamespaces: DefaultExcludedNamespaces}
+
+	policy := flags.WithConfig(config, nil)
+	expected := Policy{OptIn: OptIn{Annotation: DefaultOptInAnnotation}, ExcludedNamespaces: []string{"legacy-*"}}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Errorf("expected %+v, got %+v", expected, policy)
+	}
+
+	policy = flags.WithConfig(config, map[string]bool{"instrumentation-label": true, "excluded-namespaces": true})
+	if !reflect.DeepEqual(policy, flags) {
+		t.Errorf("expected the explicit flags to win, got %+v", policy)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := map[string][]string{
+		"":                  nil,
+		" , ":               nil,
+		"kube-*":            {"kube-*"},
+		"kube-*, legacy ,,": {"kube-*", "legacy"},
+	}
+	for value, expected := range tests {
+		if items := SplitList(value); !reflect.DeepEqual(items, expected) {
+			t.Errorf("expected %q to be split to %v, got %v", value, expected, items)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package inject
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Workload is the object which owns the instrumented pod template.
+type Workload struct {
+	Kind string
+	metav1.ObjectMeta
+}
+
+// TemplateContext is the data resource attribute templates are evaluated against.
+type TemplateContext struct {
+	Workload  WorkloadContext
+	Namespace NamespaceContext
+	Container ContainerContext
+}
+
+type WorkloadContext struct {
+	Name        string
+	Kind        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type NamespaceContext struct {
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type ContainerContext struct {
+	Name  string
+	Image string
+}
+
+func newTemplateContext(ns metav1.ObjectMeta, workload Workload, container *corev1.Container) TemplateContext {
+	return TemplateContext{
+		Workload: WorkloadContext{
+			Name:        workload.Name,
+			Kind:        workload.Kind,
+			Labels:      workload.Labels,
+			Annotations: workload.Annotations,
+		},
+		Namespace: NamespaceContext{
+			Name:        ns.Name,
+			Labels:      ns.Labels,
+			Annotations: ns.Annotations,
+		},
+		Container: ContainerContext{
+			Name:  container.Name,
+			Image: container.Image,
+		},
+	}
+}
+
+// validationContext is representative data the resource attribute templates are validated with.
+// The names have the maximum length of a DNS label, slicing them fails only if it fails for every workload.
+var validationContext = TemplateContext{
+	Workload: WorkloadContext{
+		Name:        strings.Repeat("w", 63),
+		Kind:        "Deployment",
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	},
+	Namespace: NamespaceContext{
+		Name:        strings.Repeat("n", 63),
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	},
+	Container: ContainerContext{
+		Name:  strings.Repeat("c", 63),
+		Image: "registry.example.com/" + strings.Repeat("i", 63) + ":1.0.0",
+	},
+}
+
+// Validate checks that the instrumentation spec can be injected. Resource attribute templates are parsed
+// and evaluated against representative data, an error of a template which depends on the values of a workload,
+// e.g. a missing label, is reported when the workload is instrumented.
+func Validate(instrumentation cachev1alpha1.OpenTelemetryInstrumentationSpec) error {
+	_, err := renderResourceAttributes(instrumentation.ResourceAttributes, validationContext)
+	return err
+}
+
+// renderResourceAttributes evaluates the attribute templates and returns them as comma separated key=value pairs
+// sorted by key. The values are percent-encoded as required by OTEL_RESOURCE_ATTRIBUTES.
+func renderResourceAttributes(attributes map[string]string, ctx TemplateContext) (string, error) {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		tmpl, err := template.New(k).Option("missingkey=zero").Parse(attributes[k])
+		if err != nil {
+			return "", fmt.Errorf("resource attribute %q: %w", k, err)
+		}
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, ctx); err != nil {
+			return "", fmt.Errorf("resource attribute %q: %w", k, err)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, encodeAttributeValue(value.String())))
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// encodeAttributeValue percent-encodes the characters of the value outside the W3C baggage octets,
+// the separators , and = and the % sign itself.
+func encodeAttributeValue(value string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' || c == '=' || c == '%' {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+			continue
+		}
+		encoded.WriteByte(c)
+	}
+	return encoded.String()
+}
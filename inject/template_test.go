@@ -0,0 +1,138 @@
+package inject
+
+import (
+	"testing"
+
+	cachev1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderResourceAttributes(t *testing.T) {
+	ctx := newTemplateContext(
+		metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "payments"}},
+		Workload{Kind: "Deployment", ObjectMeta: metav1.ObjectMeta{
+			Name:        "checkout",
+			Labels:      map[string]string{"app.kubernetes.io/version": "1.2.3"},
+			Annotations: map[string]string{"owner": "alice"},
+		}},
+		&corev1.Container{Name: "app", Image: "checkout:1.2.3"},
+	)
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		expected   string
+		err        bool
+	}{
+		{
+			name:     "no attributes",
+			expected: "",
+		},
+		{
+			name:       "static values sorted by key",
+			attributes: map[string]string{"b": "2", "a": "1"},
+			expected:   "a=1,b=2",
+		},
+		{
+			name: "workload, namespace and container fields",
+			attributes: map[string]string{
+				"service.version": `{{ index .Workload.Labels "app.kubernetes.io/version" }}`,
+				"owner":           `{{ .Workload.Annotations.owner }}`,
+				"team":            `{{ .Namespace.Labels.team }}`,
+				"workload":        `{{ .Workload.Kind }}/{{ .Workload.Name }}`,
+				"namespace":       `{{ .Namespace.Name }}`,
+				"image":           `{{ .Container.Name }}={{ .Container.Image }}`,
+			},
+			expected: "image=app%3Dcheckout:1.2.3,namespace=shop,owner=alice,service.version=1.2.3,team=payments,workload=Deployment/checkout",
+		},
+		{
+			name: "percent-encoded values",
+			attributes: map[string]string{
+				"tags":  `a=1,b=2`,
+				"path":  `/a b/100%`,
+				"quote": `"x";y\z`,
+				"name":  `café`,
+			},
+			expected: `name=caf%C3%A9,path=/a%20b/100%25,quote=%22x%22%3By%5Cz,tags=a%3D1%2Cb%3D2`,
+		},
+		{
+			name:       "missing key renders empty value",
+			attributes: map[string]string{"missing": `{{ .Namespace.Labels.missing }}`},
+			expected:   "missing=",
+		},
+		{
+			name:       "parse error",
+			attributes: map[string]string{"broken": `{{ .Workload.Name`},
+			err:        true,
+		},
+		{
+			name:       "execution error",
+			attributes: map[string]string{"broken": `{{ .Workload.Unknown }}`},
+			err:        true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := renderResourceAttributes(test.attributes, ctx)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", rendered)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rendered != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, rendered)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		valid      bool
+	}{
+		{
+			name:  "no attributes",
+			valid: true,
+		},
+		{
+			name:       "valid template",
+			attributes: map[string]string{"team": `{{ .Namespace.Labels.team }}`},
+			valid:      true,
+		},
+		{
+			name: "template depending on the workload values",
+			attributes: map[string]string{
+				"service.name":    `{{ slice .Container.Image 0 8 }}`,
+				"service.version": `{{ index .Workload.Labels "app.kubernetes.io/version" }}`,
+				"team":            `{{ .Namespace.Annotations.team | printf "%s-team" }}`,
+			},
+			valid: true,
+		},
+		{
+			name:       "parse error",
+			attributes: map[string]string{"team": `{{ .Namespace.Labels.team`},
+		},
+		{
+			name:       "unknown field",
+			attributes: map[string]string{"team": `{{ .Team }}`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(cachev1alpha1.OpenTelemetryInstrumentationSpec{ResourceAttributes: test.attributes})
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
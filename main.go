@@ -17,8 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -27,12 +30,18 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
 	otelinstv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
 	"github.com/pavolloffay/opentelemetry-instrumentation-operator/controllers"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,18 +54,97 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(otelinstv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "uninstall" {
+		os.Exit(runUninstall(os.Args[2:]))
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var excludedNamespaces string
+	var dryRun bool
+	var enableWebhook bool
+	var otlpEndpoint string
+	var otlpInsecure bool
+	var workloadSelector, namespaceSelector string
+	var watchNamespaces string
+	var instanceID string
+	var configFile string
+	var deploymentConcurrency, instrumentationConcurrency int
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var sweepInterval time.Duration
+	rateLimiter := controllers.DefaultRateLimiterOptions()
+	var maxRollouts, maxNamespaceRollouts int
+	optIn := inject.DefaultOptIn()
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&optIn.Label, "instrumentation-label", optIn.Label,
+		"The label which enables (true, enabled) or disables (false, disabled) the instrumentation "+
+			"on a pod template, workload or namespace. Empty string disables the label.")
+	flag.StringVar(&optIn.Annotation, "instrumentation-annotation", optIn.Annotation,
+		"The annotation which enables (true, enabled) or disables (false, disabled) the instrumentation "+
+			"on a pod template, workload or namespace. Empty string disables the annotation.")
+	flag.StringVar(&excludedNamespaces, "excluded-namespaces", strings.Join(inject.DefaultExcludedNamespaces, ","),
+		"Comma separated glob patterns of namespaces which are never instrumented, e.g. kube-*,cert-manager. "+
+			"The instrumentation is removed from workloads in these namespaces.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Run all instrumentation CRs in the audit mode. Workload updates are validated by a server-side dry-run "+
+			"and recorded as events and in the CR status, nothing is persisted.")
+	flag.IntVar(&maxRollouts, "max-concurrent-rollouts", 0,
+		"The maximum number of Deployments with an in-progress rollout in the cluster at which the operator "+
+			"starts a new rollout. Zero means unlimited.")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false,
+		"Enable the pod mutating webhook which instruments the canary pods of Deployments "+
+			"with the instrumentation.opentelemetry.io/canary-percent pod template label. Requires serving certificates.")
+	flag.IntVar(&maxNamespaceRollouts, "max-concurrent-rollouts-per-namespace", 0,
+		"The maximum number of Deployments with an in-progress rollout in a namespace at which the operator "+
+			"starts a new rollout. Zero means unlimited.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The OTLP/HTTP endpoint (host:port) the traces of the reconciliations are exported to. Empty disables the tracing.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Export the traces over plain HTTP instead of HTTPS.")
+	flag.StringVar(&workloadSelector, "workload-label-selector", "",
+		"Label selector restricting the cached and reconciled Deployments, e.g. "+controllers.ManagedLabel+". "+
+			"Deployments not matching the selector are invisible to the operator. Empty selects all Deployments.")
+	flag.StringVar(&namespaceSelector, "namespace-label-selector", "",
+		"Label selector restricting the cached and reconciled Namespaces. Empty selects all Namespaces.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated namespaces the operator watches, empty watches the whole cluster. "+
+			"Namespace objects are not read in this mode, only workload labels and annotations enable the instrumentation.")
+	flag.StringVar(&configFile, "config", "",
+		"The OperatorConfig file. Its settings replace the flags not set on the command line. The manager settings are read at the start, "+
+			"the instrumentation defaults, label keys, excluded namespaces, mode and rollout limits are reloaded when the file changes.")
+	flag.IntVar(&deploymentConcurrency, "deployment-concurrency", 0,
+		"The number of Deployments reconciled in parallel. Zero means controller.groupKindConcurrency of the config file or 1.")
+	flag.IntVar(&instrumentationConcurrency, "instrumentation-concurrency", 0,
+		"The number of instrumentation CRs reconciled in parallel. Zero means controller.groupKindConcurrency of the config file or 1.")
+	flag.DurationVar(&rateLimiter.BaseDelay, "rate-limiter-base-delay", rateLimiter.BaseDelay,
+		"The first retry delay of a failed reconciliation, the delay doubles with every failure.")
+	flag.DurationVar(&rateLimiter.MaxDelay, "rate-limiter-max-delay", rateLimiter.MaxDelay,
+		"The maximum retry delay of a failed reconciliation.")
+	flag.Float64Var(&rateLimiter.QPS, "rate-limiter-qps", rateLimiter.QPS, "The overall rate of the queued reconciliations of each reconciler.")
+	flag.IntVar(&rateLimiter.Burst, "rate-limiter-burst", rateLimiter.Burst,
+		"The number of reconciliations of each reconciler queued above the rate-limiter-qps rate.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 0,
+		"The rate of the requests to the Kubernetes API server. Zero means clientConnection.qps of the config file "+
+			"or the controller-runtime default of 20.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 0,
+		"The number of requests sent to the Kubernetes API server above the kube-api-qps rate. "+
+			"Zero means clientConnection.burst of the config file or the controller-runtime default of 30.")
+	flag.DurationVar(&sweepInterval, "sweep-interval", time.Hour,
+		"The interval of the sweeps removing the instrumentation from workloads not enabled by any instrumentation CR. "+
+			"The first sweep runs at the start, zero disables the periodic sweeps.")
+	flag.StringVar(&instanceID, "instance-id", "",
+		"The ID of the operator instance when several instances run in one cluster. The instance reconciles only the CRs "+
+			"labeled "+controllers.InstanceLabel+"=<ID> (CRs without the label if empty) and the workloads it instrumented.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,45 +153,172 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := controllers.Options{
+		OptIn:                             optIn,
+		ExcludedNamespaces:                inject.SplitList(excludedNamespaces),
+		DryRun:                            dryRun,
+		MaxConcurrentRollouts:             maxRollouts,
+		MaxConcurrentRolloutsPerNamespace: maxNamespaceRollouts,
+		WatchNamespaces:                   inject.SplitList(watchNamespaces),
+		InstanceID:                        instanceID,
+	}
+
+	if instanceID == controllers.DefaultInstanceID {
+		setupLog.Error(nil, "the instance ID is reserved for the instance without an ID", "instanceID", instanceID)
+		os.Exit(1)
+	}
+	cacheSelectors, err := controllers.CacheSelectors(workloadSelector, namespaceSelector, instanceID)
+	if err != nil {
+		setupLog.Error(err, "invalid cache label selector")
+		os.Exit(1)
+	}
+	newCache := cache.BuilderWithOptions(cache.Options{SelectorsByObject: cacheSelectors})
+	if len(options.WatchNamespaces) > 0 {
+		newCache = func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+			opts.SelectorsByObject = cacheSelectors
+			return cache.MultiNamespacedCacheBuilder(options.WatchNamespaces)(config, opts)
+		}
+	}
+
+	managerOptions := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "750ac9f9.opentelemetry.io",
-	})
+		LeaderElectionID:       leaderElectionID(instanceID),
+		NewCache:               newCache,
+	}
+	deploymentReconciler := controllers.ReconcilerOptions{MaxConcurrentReconciles: deploymentConcurrency, RateLimiter: rateLimiter}
+	instrumentationReconciler := controllers.ReconcilerOptions{MaxConcurrentReconciles: instrumentationConcurrency, RateLimiter: rateLimiter}
+	restConfig := ctrl.GetConfigOrDie()
+	if kubeAPIQPS > 0 {
+		restConfig.QPS = float32(kubeAPIQPS)
+	}
+	if kubeAPIBurst > 0 {
+		restConfig.Burst = kubeAPIBurst
+	}
+
+	flagOptions := options
+	// the flags set on the command line take precedence over the file, the file over the flag defaults
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if configFile != "" {
+		operatorConfig := configv1alpha1.OperatorConfig{}
+		fileOptions := managerOptions
+		if !set["metrics-bind-address"] {
+			fileOptions.MetricsBindAddress = ""
+		}
+		if !set["health-probe-bind-address"] {
+			fileOptions.HealthProbeBindAddress = ""
+		}
+		if instanceID == "" {
+			fileOptions.LeaderElectionID = ""
+		}
+		fileOptions.Port = 0
+		managerOptions, err = fileOptions.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&operatorConfig))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+		if managerOptions.MetricsBindAddress == "" {
+			managerOptions.MetricsBindAddress = metricsAddr
+		}
+		if managerOptions.HealthProbeBindAddress == "" {
+			managerOptions.HealthProbeBindAddress = probeAddr
+		}
+		if managerOptions.Port == 0 {
+			managerOptions.Port = 9443
+		}
+		if managerOptions.LeaderElectionID == "" {
+			managerOptions.LeaderElectionID = leaderElectionID(instanceID)
+		}
+		options = options.WithConfig(&operatorConfig, set)
+		deploymentReconciler.RateLimiter = rateLimiter.WithConfig(operatorConfig.GroupKindRateLimiters["Deployment.apps"], set)
+		instrumentationReconciler.RateLimiter = rateLimiter.WithConfig(
+			operatorConfig.GroupKindRateLimiters["OpenTelemetryInstrumentation."+otelinstv1alpha1.GroupVersion.Group], set)
+		// a zero --kube-api-* flag means not set, like a zero --*-concurrency flag the manager replaces
+		// by controller.groupKindConcurrency
+		if operatorConfig.ClientConnection.QPS != nil && kubeAPIQPS <= 0 {
+			restConfig.QPS = *operatorConfig.ClientConnection.QPS
+		}
+		if operatorConfig.ClientConnection.Burst != nil && kubeAPIBurst <= 0 {
+			restConfig.Burst = *operatorConfig.ClientConnection.Burst
+		}
+	}
+	settings := controllers.NewSettings(options)
+
+	mgr, err := ctrl.NewManager(restConfig, managerOptions)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.OpenTelemetryInstrumentationReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "OpenTelemetryInstrumentation")
-		os.Exit(1)
+	k8sClient, apiReader := mgr.GetClient(), mgr.GetAPIReader()
+	shutdownTracing := func(context.Context) error { return nil }
+	if otlpEndpoint != "" {
+		shutdownTracing, err = controllers.SetupTracing(context.Background(), otlpEndpoint, otlpInsecure)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		k8sClient = controllers.NewTracingClient(k8sClient)
+		apiReader = controllers.NewTracingReader(apiReader)
 	}
 
-	if err = (&controllers.NamespaceControllerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+	if err = (&controllers.OpenTelemetryInstrumentationReconciler{
+		Client:     k8sClient,
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("opentelemetry-instrumentation-operator"),
+		Settings:   settings,
+		Reconciler: instrumentationReconciler,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "NamespaceControllerReconciler")
+		setupLog.Error(err, "unable to create controller", "controller", "OpenTelemetryInstrumentation")
 		os.Exit(1)
 	}
 
+	rolloutGate := controllers.NewRolloutGate()
 	if err = (&controllers.DeploymentControllerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:     k8sClient,
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("opentelemetry-instrumentation-operator"),
+		APIReader:  apiReader,
+		Settings:   settings,
+		Reconciler: deploymentReconciler,
+		Gate:       rolloutGate,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DeploymentControllerReconciler")
 		os.Exit(1)
 	}
 
+	if enableWebhook {
+		mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: &controllers.PodInjector{
+			Client:    k8sClient,
+			APIReader: apiReader,
+			Settings:  settings,
+		}})
+	}
 	//+kubebuilder:scaffold:builder
 
+	metrics.Registry.MustRegister(controllers.NewCoverageCollector(mgr.GetClient(), settings))
+
+	if err := mgr.Add(&controllers.Sweeper{
+		Client:   k8sClient,
+		Recorder: mgr.GetEventRecorderFor("opentelemetry-instrumentation-operator"),
+		Settings: settings,
+		Gate:     rolloutGate,
+		Interval: sweepInterval,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up the sweeper")
+		os.Exit(1)
+	}
+	if configFile != "" {
+		if err := mgr.Add(&controllers.ConfigReloader{Path: configFile, Options: flagOptions, ExplicitFlags: set, Settings: settings}); err != nil {
+			setupLog.Error(err, "unable to set up config reloading")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -118,4 +333,15 @@ func main() {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+}
+
+// leaderElectionID returns the leader election ID of the operator instance.
+func leaderElectionID(instanceID string) string {
+	if instanceID == "" {
+		return "750ac9f9.opentelemetry.io"
+	}
+	return instanceID + ".750ac9f9.opentelemetry.io"
 }
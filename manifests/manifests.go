@@ -0,0 +1,219 @@
+// Package manifests applies the instrumentation to workload manifests without a cluster,
+// with the same semantics as the operator.
+package manifests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	configv1alpha1 "github.com/pavolloffay/opentelemetry-instrumentation-operator/api/config/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/api/v1alpha1"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// podSpecPaths are the paths of the pod specs of the supported workload kinds.
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// Supported returns true if the object is a workload with a pod spec.
+func Supported(obj *unstructured.Unstructured) bool {
+	_, ok := podSpecPaths[obj.GetKind()]
+	return ok
+}
+
+// Inject injects the instrumentation into the pod spec of the workload in the namespace. The namespace
+// name is used for the workloads without a namespace. It returns the changes of the pod spec,
+// an unsupported object is not changed.
+func Inject(obj *unstructured.Unstructured, ns metav1.ObjectMeta, spec v1alpha1.OpenTelemetryInstrumentationSpec) ([]string, error) {
+	return mutate(obj, func(pod *corev1.PodSpec) error {
+		workload := inject.Workload{Kind: obj.GetKind(), ObjectMeta: objectMeta(obj)}
+		if workload.Namespace == "" {
+			workload.Namespace = ns.Name
+		}
+		return inject.InjectPod(ns, workload, pod, spec)
+	})
+}
+
+// Enabled decides by the policy of the operator whether the workload in the namespace is instrumented.
+// The pod template of a canary workload is not instrumented, its pods are instrumented by the webhook.
+func Enabled(obj *unstructured.Unstructured, ns metav1.ObjectMeta, spec v1alpha1.OpenTelemetryInstrumentationSpec, policy inject.Policy) bool {
+	template := podTemplateMeta(obj)
+	if _, canary := inject.CanaryPercent(template); canary {
+		return false
+	}
+	return policy.Enabled(ns, objectMeta(obj), template, &spec)
+}
+
+func objectMeta(obj *unstructured.Unstructured) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        obj.GetName(),
+		Namespace:   obj.GetNamespace(),
+		Labels:      obj.GetLabels(),
+		Annotations: obj.GetAnnotations(),
+	}
+}
+
+// podTemplateMeta returns the metadata of the pod template, the metadata of the object for a Pod.
+func podTemplateMeta(obj *unstructured.Unstructured) metav1.ObjectMeta {
+	path, ok := podSpecPaths[obj.GetKind()]
+	if !ok || len(path) < 2 {
+		return objectMeta(obj)
+	}
+	meta := metav1.ObjectMeta{}
+	content, found, err := unstructured.NestedMap(obj.Object, append(path[:len(path)-1:len(path)-1], "metadata")...)
+	if err == nil && found {
+		_ = runtime.DefaultUnstructuredConverter.FromUnstructured(content, &meta)
+	}
+	return meta
+}
+
+// Clean removes the instrumentation from the pod spec of the workload.
+// It returns the changes of the pod spec, an unsupported object is not changed.
+func Clean(obj *unstructured.Unstructured) ([]string, error) {
+	return mutate(obj, func(pod *corev1.PodSpec) error {
+		inject.Clean(pod)
+		return nil
+	})
+}
+
+// mutate applies the change to the pod spec of the workload. The rest of the manifest is kept as it is.
+func mutate(obj *unstructured.Unstructured, change func(pod *corev1.PodSpec) error) ([]string, error) {
+	path, ok := podSpecPaths[obj.GetKind()]
+	if !ok {
+		return nil, nil
+	}
+	content, found, err := unstructured.NestedMap(obj.Object, path...)
+	if err != nil || !found {
+		return nil, fmt.Errorf("%s %s has no pod spec: %v", obj.GetKind(), obj.GetName(), err)
+	}
+	before := &corev1.PodSpec{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, before); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	if len(before.Containers) == 0 {
+		return nil, nil
+	}
+	after := before.DeepCopy()
+	if err := change(after); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	diff := inject.Diff(before, after)
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	converted, err := runtime.DefaultUnstructuredConverter.ToUnstructured(after)
+	if err != nil {
+		return nil, err
+	}
+	return diff, unstructured.SetNestedMap(obj.Object, prune(converted), path...)
+}
+
+// prune removes the empty container resources the conversion of the typed pod spec adds.
+func prune(pod map[string]interface{}) map[string]interface{} {
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := pod[field].([]interface{})
+		for _, c := range containers {
+			if container, ok := c.(map[string]interface{}); ok {
+				if resources, ok := container["resources"].(map[string]interface{}); ok && len(resources) == 0 {
+					delete(container, "resources")
+				}
+			}
+		}
+	}
+	return pod
+}
+
+// Read reads the objects of a multi-document YAML or JSON stream, empty documents are skipped.
+func Read(r io.Reader) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return objs, nil
+			}
+			return nil, err
+		}
+		if len(obj.Object) > 0 {
+			objs = append(objs, obj)
+		}
+	}
+}
+
+// Write writes the objects as a multi-document YAML stream.
+func Write(w io.Writer, objs []*unstructured.Unstructured) error {
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		content, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		buf.Write(content)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadInstrumentation reads the instrumentation CR, the first OpenTelemetryInstrumentation of the stream.
+func ReadInstrumentation(r io.Reader) (*v1alpha1.OpenTelemetryInstrumentation, error) {
+	objs, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if obj.GetKind() == "OpenTelemetryInstrumentation" {
+			return ToInstrumentation(obj)
+		}
+	}
+	return nil, fmt.Errorf("no OpenTelemetryInstrumentation found")
+}
+
+// ToInstrumentation converts the object to a valid instrumentation CR.
+func ToInstrumentation(obj *unstructured.Unstructured) (*v1alpha1.OpenTelemetryInstrumentation, error) {
+	instrumentation := &v1alpha1.OpenTelemetryInstrumentation{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, instrumentation); err != nil {
+		return nil, err
+	}
+	if err := inject.Validate(instrumentation.Spec); err != nil {
+		return nil, err
+	}
+	return instrumentation, nil
+}
+
+// ReadConfig reads the configuration file of the operator, the first OperatorConfig of the stream.
+func ReadConfig(r io.Reader) (*configv1alpha1.OperatorConfig, error) {
+	objs, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		if obj.GetKind() == "OperatorConfig" {
+			config := &configv1alpha1.OperatorConfig{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, config); err != nil {
+				return nil, err
+			}
+			return config, nil
+		}
+	}
+	return nil, fmt.Errorf("no OperatorConfig found")
+}
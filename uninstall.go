@@ -0,0 +1,66 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/controllers"
+	"github.com/pavolloffay/opentelemetry-instrumentation-operator/inject"
+)
+
+// runUninstall implements the uninstall subcommand. It pauses the instrumentation CRs, reverts all Deployments
+// instrumented by the operator and returns the exit code, non-zero if any Deployment or CR could not be reverted.
+func runUninstall(args []string) int {
+	flags := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	var options controllers.Options
+	var timeout, pauseDelay time.Duration
+	var watchNamespaces string
+	flags.StringVar(&options.InstanceID, "instance-id", "", "Revert only the workloads of the operator instance.")
+	flags.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated namespaces of the namespace-scoped operator, empty reverts the workloads of the whole cluster.")
+	flags.DurationVar(&pauseDelay, "pause-delay", 10*time.Second,
+		"The time the running operator gets to observe the paused instrumentation CRs before the workloads are reverted.")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "Validate the updates by a server-side dry-run, nothing is persisted.")
+	flags.DurationVar(&timeout, "timeout", 10*time.Minute, "The maximum duration of the uninstall.")
+	zapOptions := zap.Options{}
+	zapOptions.BindFlags(flags)
+	_ = flags.Parse(args)
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOptions)))
+	options.WatchNamespaces = inject.SplitList(watchNamespaces)
+	logger := ctrl.Log.WithName("uninstall")
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		logger.Error(err, "unable to create client")
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(ctrl.LoggerInto(context.Background(), logger), timeout)
+	defer cancel()
+	if err := controllers.Uninstall(ctx, c, options, pauseDelay); err != nil {
+		logger.Error(err, "uninstall failed")
+		return 1
+	}
+	logger.Info("uninstall finished")
+	return 0
+}